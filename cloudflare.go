@@ -20,10 +20,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,26 +39,62 @@ const (
 	cloudflareAPIBase = "https://api.cloudflare.com/client/v4"
 )
 
+// ErrZoneNotFound is returned by GetZoneID when no zone matches the given name
+var ErrZoneNotFound = errors.New("zone not found")
+
+// rateLimitWindow is the period Cloudflare's request limit applies to
+const rateLimitWindow = 5 * time.Minute
+
+// listPageSize is the page size used when listing zones and records, the
+// largest one every list endpoint accepts
+const listPageSize = 50
+
 // CloudflareClient handles Cloudflare API operations
 type CloudflareClient struct {
-	client *http.Client
-	config CloudflareConfig
+	client  *http.Client
+	config  CloudflareConfig
+	api     APIConfig
+	limiter *rateLimiter
 }
 
 // NewCloudflareClient creates a new Cloudflare API client
-func NewCloudflareClient(config CloudflareConfig) *CloudflareClient {
+func NewCloudflareClient(config CloudflareConfig, api APIConfig) *CloudflareClient {
 	return &CloudflareClient{
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		config: config,
+		config:  config,
+		api:     api,
+		limiter: newRateLimiter(api.RateLimit, rateLimitWindow),
 	}
 }
 
+// sameCredentials reports whether the client authenticates with creds
+func (c *CloudflareClient) sameCredentials(creds CloudflareConfig) bool {
+	return c.config.APIToken == creds.APIToken && c.config.APIKey == creds.APIKey && c.config.Email == creds.Email
+}
+
 // Zone represents a Cloudflare zone
 type Zone struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
+
+	// Permissions the credentials have on the zone, e.g. "#dns_records:edit"
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// CanEditDNS reports whether the zone's permissions allow editing DNS
+// records. Zones listed without permissions are assumed to allow it.
+func (z Zone) CanEditDNS() bool {
+	if len(z.Permissions) == 0 {
+		return true
+	}
+	for _, permission := range z.Permissions {
+		if permission == "#dns_records:edit" {
+			return true
+		}
+	}
+	return false
 }
 
 // DNSRecord represents a Cloudflare DNS record
@@ -63,11 +107,86 @@ type DNSRecord struct {
 	Proxied bool   `json:"proxied"`
 }
 
+// TokenStatus is the result of verifying an API token
+type TokenStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
 // CloudflareResponse represents the standard Cloudflare API response
 type CloudflareResponse struct {
-	Success bool        `json:"success"`
-	Errors  []CFError   `json:"errors"`
-	Result  interface{} `json:"result"`
+	Success  bool            `json:"success"`
+	Errors   []CFError       `json:"errors"`
+	Messages []CFError       `json:"messages"`
+	Result   json.RawMessage `json:"result"`
+
+	// Paging metadata of list responses
+	ResultInfo *ResultInfo `json:"result_info,omitempty"`
+}
+
+// ResultInfo is the paging metadata of a list response
+type ResultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
+}
+
+// APIError is returned for a request Cloudflare rejected, carrying everything
+// the response said about why
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	RayID      string // cf-ray header, identifies the request to Cloudflare support
+	Errors     []CFError
+	Messages   []CFError
+}
+
+// newAPIError builds an APIError from a response
+func newAPIError(resp *http.Response, cfResp CloudflareResponse) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RayID:      resp.Header.Get("Cf-Ray"),
+		Errors:     cfResp.Errors,
+		Messages:   cfResp.Messages,
+	}
+	if resp.Request != nil {
+		apiErr.Method = resp.Request.Method
+		apiErr.Path = resp.Request.URL.Path
+	}
+	return apiErr
+}
+
+// Error describes the request, the HTTP status and every error and message
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cloudflare API error: %s %s: HTTP %d", e.Method, e.Path, e.StatusCode)
+	if text := http.StatusText(e.StatusCode); text != "" {
+		b.WriteString(" " + text)
+	}
+
+	for _, cfErr := range e.Errors {
+		fmt.Fprintf(&b, "; %s (code: %d)", cfErr.Message, cfErr.Code)
+	}
+	for _, message := range e.Messages {
+		fmt.Fprintf(&b, "; message: %s", message.Message)
+	}
+	if e.RayID != "" {
+		fmt.Fprintf(&b, " [cf-ray: %s]", e.RayID)
+	}
+	return b.String()
+}
+
+// HasCode returns true if Cloudflare reported the given error code
+func (e *APIError) HasCode(code int) bool {
+	for _, cfErr := range e.Errors {
+		if cfErr.Code == code {
+			return true
+		}
+	}
+	return false
 }
 
 // CFError represents a Cloudflare API error
@@ -76,48 +195,114 @@ type CFError struct {
 	Message string `json:"message"`
 }
 
-// GetZoneID retrieves the zone ID for a domain
-func (c *CloudflareClient) GetZoneID(domain string) (string, error) {
-	if c.config.ZoneID != "" {
-		return c.config.ZoneID, nil
+// VerifyCredentials checks that Cloudflare accepts the credentials. API
+// tokens must also be active; API keys are checked by fetching the user.
+func (c *CloudflareClient) VerifyCredentials(ctx context.Context) error {
+	if c.config.APIToken == "" {
+		if _, err := c.makeRequest(ctx, "GET", cloudflareAPIBase+"/user", nil); err != nil {
+			return fmt.Errorf("API key verification failed: %w", err)
+		}
+		return nil
 	}
 
-	url := fmt.Sprintf("%s/zones?name=%s", cloudflareAPIBase, domain)
-	resp, err := c.makeRequest("GET", url, nil)
+	resp, err := c.makeRequest(ctx, "GET", cloudflareAPIBase+"/user/tokens/verify", nil)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("API token verification failed: %w", err)
+	}
+
+	var token TokenStatus
+	if err := json.Unmarshal(resp, &token); err != nil {
+		return fmt.Errorf("failed to parse token verification response: %w", err)
+	}
+	if token.Status != "active" {
+		return fmt.Errorf("API token is %s", token.Status)
 	}
+	return nil
+}
 
-	var zones []Zone
-	if err := json.Unmarshal(resp, &zones); err != nil {
-		return "", fmt.Errorf("failed to parse zones response: %w", err)
+// ListZones returns every zone the credentials can access
+func (c *CloudflareClient) ListZones(ctx context.Context) ([]Zone, error) {
+	return listAll[Zone](ctx, c, "/zones", nil)
+}
+
+// GetZoneID retrieves the zone ID for a domain
+func (c *CloudflareClient) GetZoneID(ctx context.Context, domain string) (string, error) {
+	zones, err := listAll[Zone](ctx, c, "/zones", url.Values{"name": {domain}})
+	if err != nil {
+		return "", err
 	}
 
 	if len(zones) == 0 {
-		return "", fmt.Errorf("zone not found for domain %s", domain)
+		return "", fmt.Errorf("%w for domain %s", ErrZoneNotFound, domain)
 	}
 
 	return zones[0].ID, nil
 }
 
-// GetDNSRecords retrieves DNS records for a domain
-func (c *CloudflareClient) GetDNSRecords(zoneID, name, recordType string) ([]DNSRecord, error) {
-	url := fmt.Sprintf("%s/zones/%s/dns_records?name=%s&type=%s", cloudflareAPIBase, zoneID, name, recordType)
-	resp, err := c.makeRequest("GET", url, nil)
+// GetZone retrieves a zone by ID
+func (c *CloudflareClient) GetZone(ctx context.Context, zoneID string) (*Zone, error) {
+	resp, err := c.makeRequest(ctx, "GET", cloudflareAPIBase+"/zones/"+zoneID, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var records []DNSRecord
-	if err := json.Unmarshal(resp, &records); err != nil {
-		return nil, fmt.Errorf("failed to parse DNS records response: %w", err)
+	var zone Zone
+	if err := json.Unmarshal(resp, &zone); err != nil {
+		return nil, fmt.Errorf("failed to parse zone: %w", err)
 	}
+	return &zone, nil
+}
 
-	return records, nil
+// GetDNSRecords retrieves DNS records for a domain
+func (c *CloudflareClient) GetDNSRecords(ctx context.Context, zoneID, name, recordType string) ([]DNSRecord, error) {
+	query := url.Values{"name": {name}, "type": {recordType}}
+	return listAll[DNSRecord](ctx, c, "/zones/"+zoneID+"/dns_records", query)
+}
+
+// listAll fetches every page of a list endpoint, with optional filters
+func listAll[T any](ctx context.Context, c *CloudflareClient, path string, filters url.Values) ([]T, error) {
+	var all []T
+	for page := 1; ; page++ {
+		items, info, err := listPage[T](ctx, c, path, filters, page, listPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, items...)
+		if page >= info.TotalPages || len(items) == 0 {
+			return all, nil
+		}
+	}
+}
+
+// listPage fetches one page of a list endpoint
+func listPage[T any](ctx context.Context, c *CloudflareClient, path string, filters url.Values, page, perPage int) ([]T, ResultInfo, error) {
+	query := url.Values{}
+	for key, values := range filters {
+		query[key] = values
+	}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+
+	resp, err := c.send(ctx, "GET", cloudflareAPIBase+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, ResultInfo{}, err
+	}
+
+	var items []T
+	if err := json.Unmarshal(resp.Result, &items); err != nil {
+		return nil, ResultInfo{}, fmt.Errorf("failed to parse %s response: %w", path, err)
+	}
+
+	var info ResultInfo
+	if resp.ResultInfo != nil {
+		info = *resp.ResultInfo
+	}
+	return items, info, nil
 }
 
 // CreateDNSRecord creates a new DNS record
-func (c *CloudflareClient) CreateDNSRecord(zoneID string, record DNSRecord) (*DNSRecord, error) {
+func (c *CloudflareClient) CreateDNSRecord(ctx context.Context, zoneID string, record DNSRecord) (*DNSRecord, error) {
 	url := fmt.Sprintf("%s/zones/%s/dns_records", cloudflareAPIBase, zoneID)
 
 	payload, err := json.Marshal(record)
@@ -125,7 +310,7 @@ func (c *CloudflareClient) CreateDNSRecord(zoneID string, record DNSRecord) (*DN
 		return nil, fmt.Errorf("failed to marshal DNS record: %w", err)
 	}
 
-	resp, err := c.makeRequest("POST", url, payload)
+	resp, err := c.makeRequest(ctx, "POST", url, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +324,7 @@ func (c *CloudflareClient) CreateDNSRecord(zoneID string, record DNSRecord) (*DN
 }
 
 // UpdateDNSRecord updates an existing DNS record
-func (c *CloudflareClient) UpdateDNSRecord(zoneID, recordID string, record DNSRecord) (*DNSRecord, error) {
+func (c *CloudflareClient) UpdateDNSRecord(ctx context.Context, zoneID, recordID string, record DNSRecord) (*DNSRecord, error) {
 	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", cloudflareAPIBase, zoneID, recordID)
 
 	payload, err := json.Marshal(record)
@@ -147,7 +332,7 @@ func (c *CloudflareClient) UpdateDNSRecord(zoneID, recordID string, record DNSRe
 		return nil, fmt.Errorf("failed to marshal DNS record: %w", err)
 	}
 
-	resp, err := c.makeRequest("PUT", url, payload)
+	resp, err := c.makeRequest(ctx, "PUT", url, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -160,19 +345,70 @@ func (c *CloudflareClient) UpdateDNSRecord(zoneID, recordID string, record DNSRe
 	return &updatedRecord, nil
 }
 
-// makeRequest makes an HTTP request to the Cloudflare API
-func (c *CloudflareClient) makeRequest(method, url string, body []byte) ([]byte, error) {
+// DeleteDNSRecord deletes a DNS record
+func (c *CloudflareClient) DeleteDNSRecord(ctx context.Context, zoneID, recordID string) error {
+	url := fmt.Sprintf("%s/zones/%s/dns_records/%s", cloudflareAPIBase, zoneID, recordID)
+	_, err := c.makeRequest(ctx, "DELETE", url, nil)
+	return err
+}
+
+// makeRequest makes an HTTP request to the Cloudflare API and returns the
+// result as JSON
+func (c *CloudflareClient) makeRequest(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	resp, err := c.send(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// send makes an HTTP request to the Cloudflare API, retrying network errors,
+// 5xx and 429 responses with jittered exponential backoff
+func (c *CloudflareClient) send(ctx context.Context, method, url string, body []byte) (*CloudflareResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, respBody, err := c.doRequest(ctx, method, url, body)
+
+		reason := retryReason(method, resp, err)
+		if reason == "" || attempt >= c.api.MaxAttempts || ctx.Err() != nil {
+			if err != nil {
+				return nil, err
+			}
+			return parseResponse(resp, respBody)
+		}
+
+		delay := c.retryDelay(attempt, resp)
+		slog.Warn("Retrying Cloudflare API request", "method", method, "url", url,
+			"reason", reason, "attempt", attempt, "delay", delay)
+		metrics.ObserveAPIRetry(reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// doRequest waits for the rate limiter and makes a single request, returning
+// the response with its body already read
+func (c *CloudflareClient) doRequest(ctx context.Context, method, url string, body []byte) (*http.Response, []byte, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, nil, err
+	}
+
 	var req *http.Request
 	var err error
 
 	if body != nil {
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(body))
+		req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	} else {
-		req, err = http.NewRequest(method, url, nil)
+		req, err = http.NewRequestWithContext(ctx, method, url, nil)
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
@@ -186,36 +422,96 @@ func (c *CloudflareClient) makeRequest(method, url string, body []byte) ([]byte,
 		req.Header.Set("X-Auth-Email", c.config.Email)
 	}
 
+	start := time.Now()
 	resp, err := c.client.Do(req)
+	metrics.ObserveAPILatency(method, time.Since(start))
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return resp, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return resp, respBody, nil
+}
+
+// retryReason returns why a request should be retried, or "" if it should
+// not. POST requests are only retried when they certainly did not reach
+// Cloudflare, so a record is never created twice.
+func retryReason(method string, resp *http.Response, err error) string {
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return "network"
+		}
+		if resp == nil && method != http.MethodPost && !errors.Is(err, context.Canceled) {
+			return "network"
+		}
+		return ""
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "rate_limited"
+	case resp.StatusCode >= 500 && method != http.MethodPost:
+		return "server_error"
+	}
+	return ""
+}
+
+// retryDelay returns how long to wait before the next attempt, honouring a
+// Retry-After header if the response has one. Either way the delay is
+// capped at max_retry_delay.
+func (c *CloudflareClient) retryDelay(attempt int, resp *http.Response) time.Duration {
+	maxDelay := time.Duration(c.api.MaxRetryDelay) * time.Second
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(delay, maxDelay)
+		}
+	}
+
+	delay := time.Duration(c.api.RetryDelay) * time.Second << (attempt - 1)
+	if delay <= 0 || delay > maxDelay {
+		delay = maxDelay
 	}
 
+	// Wait somewhere between half and all of the delay
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as a date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date)), true
+	}
+	return 0, false
+}
+
+// parseResponse checks a Cloudflare API response and returns it parsed.
+// Unsuccessful responses are returned as an *APIError.
+func parseResponse(resp *http.Response, respBody []byte) (*CloudflareResponse, error) {
 	// Parse response
 	var cfResp CloudflareResponse
 	unmarshalErr := json.Unmarshal(respBody, &cfResp)
 	if unmarshalErr != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	if !cfResp.Success {
-		if len(cfResp.Errors) > 0 {
-			return nil, fmt.Errorf("cloudflare API error: %s (code: %d)", cfResp.Errors[0].Message, cfResp.Errors[0].Code)
+		if resp.StatusCode >= 400 {
+			return nil, newAPIError(resp, cfResp)
 		}
-		return nil, fmt.Errorf("cloudflare API request failed")
+		return nil, fmt.Errorf("failed to parse response (HTTP %d): %w", resp.StatusCode, unmarshalErr)
 	}
 
-	// Return the result as JSON
-	result, err := json.Marshal(cfResp.Result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	if !cfResp.Success || resp.StatusCode >= 400 {
+		return nil, newAPIError(resp, cfResp)
 	}
 
-	return result, nil
+	return &cfResp, nil
 }
@@ -0,0 +1,290 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pageQueries returns the page parameter of each list request
+func pageQueries(api *fakeDNSAPI) []string {
+	var pages []string
+	for _, query := range api.lists {
+		pages = append(pages, query.Get("page"))
+	}
+	return pages
+}
+
+func TestListPagination(t *testing.T) {
+	var zones []Zone
+	for i := 1; i <= 5; i++ {
+		zones = append(zones, Zone{ID: fmt.Sprintf("z%d", i), Name: fmt.Sprintf("example%d.com", i)})
+	}
+
+	tests := []struct {
+		name         string
+		pageSize     int
+		noResultInfo bool
+		extraPage    bool
+		want         []string
+		pages        []string
+	}{
+		{"single page", 0, false, false, []string{"z1", "z2", "z3", "z4", "z5"}, []string{"1"}},
+		{"several pages", 2, false, false, []string{"z1", "z2", "z3", "z4", "z5"}, []string{"1", "2", "3"}},
+		{"exact pages", 5, false, false, []string{"z1", "z2", "z3", "z4", "z5"}, []string{"1"}},
+		{"empty final page", 5, false, true, []string{"z1", "z2", "z3", "z4", "z5"}, []string{"1", "2"}},
+		{"missing result_info", 2, true, false, []string{"z1", "z2"}, []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, cf := startFakeDNSAPI(t, "z1", nil)
+			api.zones = zones
+			api.pageSize, api.noResultInfo, api.extraPage = tt.pageSize, tt.noResultInfo, tt.extraPage
+
+			listed, err := cf.ListZones(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, zone := range listed {
+				got = append(got, zone.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("zones = %v, want %v", got, tt.want)
+			}
+			if pages := pageQueries(api); !slices.Equal(pages, tt.pages) {
+				t.Errorf("requested pages %v, want %v", pages, tt.pages)
+			}
+			for _, query := range api.lists {
+				if query.Get("per_page") != fmt.Sprint(listPageSize) {
+					t.Errorf("per_page = %q, want %d", query.Get("per_page"), listPageSize)
+				}
+			}
+		})
+	}
+}
+
+func TestGetDNSRecordsPagination(t *testing.T) {
+	var records []DNSRecord
+	for i := 1; i <= 5; i++ {
+		records = append(records,
+			DNSRecord{ID: fmt.Sprintf("a%d", i), Type: "A", Name: "home.example.com", Content: fmt.Sprintf("203.0.113.%d", i)},
+			DNSRecord{ID: fmt.Sprintf("aaaa%d", i), Type: "AAAA", Name: "home.example.com", Content: fmt.Sprintf("2001:db8::%d", i)},
+			DNSRecord{ID: fmt.Sprintf("other%d", i), Type: "A", Name: "www.example.com", Content: "198.51.100.1"},
+		)
+	}
+	api, cf := startFakeDNSAPI(t, "zone1", records)
+	api.pageSize = 2
+
+	listed, err := cf.GetDNSRecords(context.Background(), "zone1", "home.example.com", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, record := range listed {
+		got = append(got, record.ID)
+	}
+	if want := []string{"a1", "a2", "a3", "a4", "a5"}; !slices.Equal(got, want) {
+		t.Errorf("records = %v, want %v", got, want)
+	}
+
+	if pages := pageQueries(api); !slices.Equal(pages, []string{"1", "2", "3"}) {
+		t.Errorf("requested pages %v, want [1 2 3]", pages)
+	}
+	for _, query := range api.lists {
+		if query.Get("name") != "home.example.com" || query.Get("type") != "A" {
+			t.Errorf("filters not passed on every page: %v", query)
+		}
+	}
+}
+
+func TestGetZoneIDFilter(t *testing.T) {
+	api, cf := startFakeDNSAPI(t, "z1", nil)
+	api.zones = []Zone{{ID: "z1", Name: "example.com"}, {ID: "z2", Name: "example.org"}}
+
+	id, err := cf.GetZoneID(context.Background(), "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "z2" {
+		t.Errorf("zone ID = %q, want z2", id)
+	}
+	if len(api.lists) != 1 || api.lists[0].Get("name") != "example.org" {
+		t.Errorf("list requests = %v, want one filtered by name", api.lists)
+	}
+
+	if _, err := cf.GetZoneID(context.Background(), "missing.net"); !errors.Is(err, ErrZoneNotFound) {
+		t.Errorf("error = %v, want %v", err, ErrZoneNotFound)
+	}
+}
+
+// startStatusAPI runs a fake Cloudflare API answering successive requests
+// with statuses, repeating the last one, and returns a client talking to it
+// and a function reporting the number of requests served
+func startStatusAPI(t *testing.T, api APIConfig, statuses ...int) (*CloudflareClient, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		status := statuses[min(requests, len(statuses)-1)]
+		requests++
+		mu.Unlock()
+
+		if status != http.StatusOK {
+			// Retry at once so the tests don't wait for the backoff
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"success": false, "errors": [{"code": %d, "message": "failed"}]}`, status)
+			return
+		}
+		fmt.Fprint(w, `{"success": true, "errors": [], "result": {"id": "z1"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	base, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cf := NewCloudflareClient(CloudflareConfig{APIToken: "test"}, api)
+	cf.client.Transport = rewriteHost{base: base}
+	return cf, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		maxAttempts int
+		statuses    []int
+		wantStatus  int // 0 for success
+		requests    int
+	}{
+		{"success", http.MethodGet, 4, []int{200}, 0, 1},
+		{"429 then success", http.MethodGet, 4, []int{429, 200}, 0, 2},
+		{"5xx then success", http.MethodPut, 4, []int{502, 503, 200}, 0, 3},
+		{"5xx up to max_attempts", http.MethodGet, 3, []int{500, 502, 503, 200}, 503, 3},
+		{"no retries with one attempt", http.MethodGet, 1, []int{500, 200}, 500, 1},
+		{"4xx not retried", http.MethodGet, 4, []int{403, 200}, 403, 1},
+		{"404 not retried", http.MethodDelete, 4, []int{404, 200}, 404, 1},
+		{"POST 5xx not retried", http.MethodPost, 4, []int{500, 200}, 500, 1},
+		{"POST 429 retried", http.MethodPost, 4, []int{429, 200}, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, requests := startStatusAPI(t, APIConfig{MaxAttempts: tt.maxAttempts}, tt.statuses...)
+
+			_, err := cf.send(context.Background(), tt.method, cloudflareAPIBase+"/zones/z1", nil)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+					t.Fatalf("error = %v, want an API error with status %d", err, tt.wantStatus)
+				}
+			}
+			if got := requests(); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestSendStopsRetryingWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	base, _ := url.Parse(server.URL)
+	api := APIConfig{}
+	if err := api.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cf := NewCloudflareClient(CloudflareConfig{APIToken: "test"}, api)
+	cf.client.Transport = rewriteHost{base: base}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cf.send(ctx, http.MethodGet, cloudflareAPIBase+"/zones", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("send waited %v for the Retry-After despite the deadline", elapsed)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cf := &CloudflareClient{api: APIConfig{RetryDelay: 1, MaxRetryDelay: 60}}
+	withRetryAfter := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {value}}}
+	}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		resp     *http.Response
+		min, max time.Duration
+	}{
+		{"seconds", 1, withRetryAfter("30"), 30 * time.Second, 30 * time.Second},
+		{"zero seconds", 3, withRetryAfter("0"), 0, 0},
+		{"seconds capped", 1, withRetryAfter("3600"), 60 * time.Second, 60 * time.Second},
+		{"date", 1, withRetryAfter(time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat)), 18 * time.Second, 20 * time.Second},
+		{"date capped", 1, withRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)), 60 * time.Second, 60 * time.Second},
+		{"date in the past", 1, withRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)), 0, 0},
+		{"invalid header uses backoff", 1, withRetryAfter("soon"), 500 * time.Millisecond, time.Second},
+		{"negative seconds use backoff", 2, withRetryAfter("-5"), time.Second, 2 * time.Second},
+		{"no response", 1, nil, 500 * time.Millisecond, time.Second},
+		{"backoff doubles", 3, &http.Response{Header: http.Header{}}, 2 * time.Second, 4 * time.Second},
+		{"backoff capped", 10, nil, 30 * time.Second, 60 * time.Second},
+		{"backoff overflow capped", 80, nil, 30 * time.Second, 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 20 {
+				got := cf.retryDelay(tt.attempt, tt.resp)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("delay = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// Exit codes
+const (
+	exitOK             = 0
+	exitError          = 1 // an update, lookup or API check failed
+	exitChangesPending = 2 // plan/status: records differ from the detected IPs
+	exitConfigError    = 3 // the configuration could not be loaded or is invalid
+	exitUsage          = 64
+)
+
+// command is a CLI subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+
+	// aliases are other names the command answers to
+	aliases []string
+}
+
+// commands returns the available subcommands
+func commands() []command {
+	return []command{
+		{"run", "Update DNS records every interval until stopped (default)", cmdRun, nil},
+		{"once", "Update DNS records once and exit", cmdOnce, nil},
+		{"plan", "Show the DNS changes an update would make without making them", cmdPlan, []string{"check"}},
+		{"status", "Show each record's Cloudflare content next to the detected IP", cmdStatus, nil},
+		{"validate", "Check the configuration and Cloudflare credentials", cmdValidate, nil},
+		{"version", "Show version information", cmdVersion, nil},
+	}
+}
+
+// runCLI dispatches to a subcommand and returns the process exit code.
+// Without a subcommand the original flat flags (-once, -dry-run, -version)
+// are accepted.
+func runCLI(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runLegacy(args)
+	}
+
+	name := args[0]
+	if name == "help" {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	for _, cmd := range commands() {
+		if cmd.name == name || slices.Contains(cmd.aliases, name) {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// printUsage lists the subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "%s v%s\n\n", AppName, Version)
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands() {
+		name := strings.Join(append([]string{cmd.name}, cmd.aliases...), ", ")
+		fmt.Fprintf(table, "  %s\t%s\n", name, cmd.summary)
+	}
+	table.Flush()
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+}
+
+// options are the flags shared by the commands that load the configuration
+type options struct {
+	configFile string
+	verbose    bool
+	logFile    string
+}
+
+// newFlagSet creates a flag set for a command with the shared flags
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&opts.configFile, "config", "cf-ddns.conf", "Path to configuration file")
+	flags.BoolVar(&opts.verbose, "verbose", false, "Enable verbose logging")
+	flags.StringVar(&opts.logFile, "log", "", "Log file path (optional, logs to stdout if not specified)")
+	return flags
+}
+
+// parseFlags parses a command's flags. It returns false with the exit code
+// if the command should not run.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected argument %q\n", flags.Arg(0))
+		flags.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// setup loads the configuration and configures logging. Quiet commands only
+// log warnings and errors unless debug logging was requested, so their
+// output stays readable.
+func setup(opts options, quiet bool) (*Config, int) {
+	config, err := loadConfig(opts.configFile)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err)
+		return nil, exitConfigError
+	}
+
+	// Command line flag takes precedence over config file
+	logFile := opts.logFile
+	if logFile == "" {
+		logFile = config.LogFile
+	}
+
+	if err := setLogLevel(config.LogLevel, opts.verbose || config.Verbose); err != nil {
+		slog.Error("Failed to setup logging", "error", err)
+		return nil, exitConfigError
+	}
+	if quiet && logLevel.Level() == slog.LevelInfo {
+		logLevel.Set(slog.LevelWarn)
+	}
+	if err := setupLogging(logFile, config.LogFormat, config.logRotation()); err != nil {
+		slog.Error("Failed to setup logging", "error", err)
+		return nil, exitError
+	}
+
+	slog.Info("Loaded configuration", "path", config.path)
+	return config, exitOK
+}
+
+// signalContext returns a context cancelled on SIGINT/SIGTERM, so in-flight
+// requests abort cleanly, and reopens the log file on SIGUSR1
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go handleLogReopen(ctx)
+	return ctx, stop
+}
+
+// runLegacy handles the flat flags used before subcommands were added
+func runLegacy(args []string) int {
+	var opts options
+	flags := newFlagSet(os.Args[0], &opts)
+	onceFlag := flags.Bool("once", false, "Run once and exit (ignore interval setting)")
+	dryRunFlag := flags.Bool("dry-run", false, "Show the DNS changes an update would make without making them, then exit")
+	versionFlag := flags.Bool("version", false, "Show version information and exit")
+	flags.Usage = func() {
+		printUsage(flags.Output())
+		fmt.Fprintf(flags.Output(), "\nWithout a command, updates run as with 'run' and these flags are accepted:\n")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	switch {
+	case *versionFlag:
+		return cmdVersion(nil)
+	case *dryRunFlag:
+		return runPlan(opts)
+	case *onceFlag:
+		return runOnce(opts)
+	default:
+		return runUpdates(opts)
+	}
+}
+
+// cmdRun runs updates every interval until stopped
+func cmdRun(args []string) int {
+	var opts options
+	flags := newFlagSet("run", &opts)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	return runUpdates(opts)
+}
+
+// runUpdates updates continuously, or once when no interval is configured
+func runUpdates(opts options) int {
+	fmt.Printf("%s v%s\n", AppName, Version)
+
+	// Catch SIGHUP from the start, so a reload during startup or preflight
+	// doesn't terminate the process; it is handled once updates run
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	config, code := setup(opts, false)
+	if config == nil {
+		return code
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	updater := NewDDNSUpdater(config)
+	if !preflight(ctx, updater) {
+		return exitError
+	}
+	if config.Interval <= 0 {
+		return update(ctx, updater)
+	}
+
+	runContinuous(ctx, updater, reload, opts.configFile, opts.verbose)
+	return exitOK
+}
+
+// cmdOnce updates DNS records once
+func cmdOnce(args []string) int {
+	var opts options
+	flags := newFlagSet("once", &opts)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	return runOnce(opts)
+}
+
+// runOnce updates DNS records once, ignoring the interval
+func runOnce(opts options) int {
+	fmt.Printf("%s v%s\n", AppName, Version)
+
+	config, code := setup(opts, false)
+	if config == nil {
+		return code
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	updater := NewDDNSUpdater(config)
+	if !preflight(ctx, updater) {
+		return exitError
+	}
+	return update(ctx, updater)
+}
+
+// preflight runs the startup checks unless disabled in the configuration.
+// It returns false if updates should not start. When Cloudflare can't be
+// reached the checks are skipped, as the network may not be up yet.
+func preflight(ctx context.Context, updater *DDNSUpdater) bool {
+	if updater.config.SkipPreflight {
+		return true
+	}
+
+	results := updater.Preflight(ctx)
+	logPreflight(results)
+	if preflightFailed(results) {
+		slog.Error("Preflight checks failed, fix the credentials or domains above (or set skip_preflight = true)")
+		return false
+	}
+	return true
+}
+
+// update runs a single update cycle
+func update(ctx context.Context, updater *DDNSUpdater) int {
+	if err := updater.Update(ctx); err != nil {
+		slog.Error("Failed to update DNS records", "error", err)
+		return exitError
+	}
+	slog.Info("DNS records updated successfully")
+	return exitOK
+}
+
+// cmdPlan shows the changes an update would make
+func cmdPlan(args []string) int {
+	var opts options
+	flags := newFlagSet("plan", &opts)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	return runPlan(opts)
+}
+
+// runPlan prints the changes an update would make and returns the exit code:
+// 0 when everything is up to date, 2 when changes are pending and 1 on error
+func runPlan(opts options) int {
+	config, code := setup(opts, true)
+	if config == nil {
+		return code
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	plan, err := NewDDNSUpdater(config).Plan(ctx)
+	if plan != nil && len(plan.Changes) > 0 {
+		if printErr := plan.Print(os.Stdout); printErr != nil {
+			slog.Error("Failed to print plan", "error", printErr)
+			return exitError
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to plan DNS updates", "error", err)
+		return exitError
+	}
+	if plan.Pending() {
+		return exitChangesPending
+	}
+	fmt.Println("DNS records are up to date, no changes planned")
+	return exitOK
+}
+
+// cmdStatus prints each configured record's Cloudflare content next to the
+// detected IP. It exits 2 if any record is out of date and 1 if an address
+// could not be detected, listing the affected records as unknown.
+func cmdStatus(args []string) int {
+	var opts options
+	flags := newFlagSet("status", &opts)
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	config, code := setup(opts, true)
+	if config == nil {
+		return code
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	plan, err := NewDDNSUpdater(config).Plan(ctx)
+	if plan != nil {
+		if printErr := plan.PrintStatus(os.Stdout); printErr != nil {
+			slog.Error("Failed to print status", "error", printErr)
+			return exitError
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to get record status", "error", err)
+		return exitError
+	}
+	if plan.Pending() {
+		return exitChangesPending
+	}
+	return exitOK
+}
+
+// cmdValidate checks the configuration and, unless -offline is given, runs
+// the preflight checks against the Cloudflare API
+func cmdValidate(args []string) int {
+	var opts options
+	flags := newFlagSet("validate", &opts)
+	offline := flags.Bool("offline", false, "Only check the configuration file, without contacting Cloudflare")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	config, code := setup(opts, true)
+	if config == nil {
+		return code
+	}
+	fmt.Printf("Configuration %s is valid: %d domain(s)\n", config.path, len(config.Domains))
+	if *offline {
+		return exitOK
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	results := NewDDNSUpdater(config).Preflight(ctx)
+	if err := printPreflight(os.Stdout, results); err != nil {
+		slog.Error("Failed to print preflight results", "error", err)
+		return exitError
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+// cmdVersion prints version information
+func cmdVersion(args []string) int {
+	flags := flag.NewFlagSet("version", flag.ContinueOnError)
+	short := flags.Bool("short", false, "Only print the version number")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if *short {
+		fmt.Println(Version)
+		return exitOK
+	}
+	fmt.Printf("%s v%s (%s, %s/%s)\n", AppName, Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return exitOK
+}
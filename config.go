@@ -20,7 +20,10 @@ package main
 
 import (
 	"fmt"
+	"net"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -30,6 +33,9 @@ type Config struct {
 	// Cloudflare API credentials
 	Cloudflare CloudflareConfig `toml:"cloudflare"`
 
+	// Named Cloudflare credential sets, referenced from domains
+	Credentials map[string]CloudflareConfig `toml:"credentials,omitempty"`
+
 	// Domains to update
 	Domains []DomainConfig `toml:"domains"`
 
@@ -41,6 +47,64 @@ type Config struct {
 
 	// Optional log file path
 	LogFile string `toml:"log_file,omitempty"`
+
+	// Rotate the log file once it exceeds this many megabytes (0 = never)
+	LogMaxSize int `toml:"log_max_size,omitempty"`
+
+	// Rotate the log file once it is this many days old (0 = never)
+	LogMaxAge int `toml:"log_max_age,omitempty"`
+
+	// Number of rotated log files to keep (default: 5)
+	LogMaxBackups int `toml:"log_max_backups,omitempty"`
+
+	// Gzip rotated log files
+	LogCompress bool `toml:"log_compress,omitempty"`
+
+	// Log output format: "text" (default) or "json"
+	LogFormat string `toml:"log_format,omitempty"`
+
+	// Minimum log level: "debug", "info" (default), "warn" or "error".
+	// verbose = true is the same as "debug".
+	LogLevel string `toml:"log_level,omitempty"`
+
+	// Optional state file remembering last known IPs and record IDs
+	StateFile string `toml:"state_file,omitempty"`
+
+	// Seconds between full reconciles that ignore the state cache (default: 3600)
+	ReconcileInterval int `toml:"reconcile_interval,omitempty"`
+
+	// Where to detect IP addresses: "http" (default), "interface" or "dns".
+	// Ignored for a family that has providers listed in [ip_detection].
+	IPSource string `toml:"ip_source,omitempty"`
+
+	// Network interface to read addresses from when ip_source = "interface"
+	Interface string `toml:"interface,omitempty"`
+
+	// IP detection providers, overriding ip_source when set
+	IPDetection IPDetectionConfig `toml:"ip_detection,omitempty"`
+
+	// Named WAN sources whose addresses are detected separately, so a domain
+	// can publish one record per uplink
+	WAN []WANConfig `toml:"wan,omitempty"`
+
+	// Address for the optional HTTP listener serving /metrics, /healthz and
+	// /readyz (e.g. "127.0.0.1:9101")
+	HTTPListen string `toml:"http_listen,omitempty"`
+
+	// Notifications about record changes and failures
+	Notifications NotificationsConfig `toml:"notifications,omitempty"`
+
+	// Cloudflare API retries and rate limiting
+	API APIConfig `toml:"api,omitempty"`
+
+	// Skip the startup checks of credentials, zones and permissions
+	SkipPreflight bool `toml:"skip_preflight,omitempty"`
+
+	// Reload automatically when the configuration file changes
+	WatchConfig bool `toml:"watch_config,omitempty"`
+
+	// Path the configuration was loaded from
+	path string
 }
 
 // CloudflareConfig contains Cloudflare API settings
@@ -50,10 +114,148 @@ type CloudflareConfig struct {
 	APIKey   string `toml:"api_key,omitempty"`
 	Email    string `toml:"email,omitempty"`
 
-	// Zone ID (optional, will be auto-detected if not provided)
+	// Zone ID (optional, will be auto-detected if not provided).
+	// Used for the domains inside this zone that rely on these credentials
+	// and have no zone_id of their own.
 	ZoneID string `toml:"zone_id,omitempty"`
 }
 
+// HasCredentials returns true if an API token or API key + email is set
+func (c *CloudflareConfig) HasCredentials() bool {
+	return c.APIToken != "" || (c.APIKey != "" && c.Email != "")
+}
+
+// logRotation returns the log file rotation settings
+func (c *Config) logRotation() logRotation {
+	return logRotation{
+		MaxSize:    int64(c.LogMaxSize) * 1024 * 1024,
+		MaxAge:     time.Duration(c.LogMaxAge) * 24 * time.Hour,
+		MaxBackups: c.LogMaxBackups,
+		Compress:   c.LogCompress,
+	}
+}
+
+// IPDetectionConfig lists the IP providers to try, in order
+type IPDetectionConfig struct {
+	// Detection mode: "first" (default) uses the first provider that answers,
+	// "consensus" queries all providers and requires a quorum to agree
+	Mode string `toml:"mode,omitempty"`
+
+	// Number of providers that must agree in consensus mode (default: majority)
+	Quorum int `toml:"quorum,omitempty"`
+
+	// Local address HTTP providers send their requests from
+	SourceAddress string `toml:"source_address,omitempty"`
+
+	// Network interface HTTP providers send their requests through
+	// (SO_BINDTODEVICE, Linux only)
+	Interface string `toml:"interface,omitempty"`
+
+	IPv4 []IPProviderConfig `toml:"ipv4,omitempty"`
+	IPv6 []IPProviderConfig `toml:"ipv6,omitempty"`
+}
+
+// WANConfig is a WAN source with its own IP providers. A family without
+// providers is not detected for this source.
+type WANConfig struct {
+	// Name referenced from the wan option of domains
+	Name string `toml:"name"`
+
+	IPDetectionConfig
+}
+
+// IPProviderConfig configures a single IP provider
+type IPProviderConfig struct {
+	// Provider type: "http", "http_json", "interface", "command", "dns",
+	// "upnp" or "natpmp"
+	Type string `toml:"type"`
+
+	// Service URL for "http" and "http_json" providers, or the device
+	// description URL for "upnp" (discovered via SSDP when empty)
+	URL string `toml:"url,omitempty"`
+
+	// Dot-separated path to the address in the JSON response (e.g. "data.ip")
+	Field string `toml:"field,omitempty"`
+
+	// Network interface for "interface" providers
+	Interface string `toml:"interface,omitempty"`
+
+	// Command and arguments for "command" providers; it must print the address
+	Command []string `toml:"command,omitempty"`
+
+	// DNS server for "dns" providers. Together with query it defaults to
+	// resolver1.opendns.com for IN class A lookups and is required otherwise.
+	Resolver string `toml:"resolver,omitempty"`
+
+	// Name queried by "dns" providers (default: myip.opendns.com, see resolver)
+	Query string `toml:"query,omitempty"`
+
+	// DNS class for "dns" providers: "IN" (default) or "CH"
+	Class string `toml:"class,omitempty"`
+
+	// Record type for "dns" providers: "A" (A/AAAA by family, default) or "TXT".
+	// CH class queries always use TXT.
+	RecordType string `toml:"record_type,omitempty"`
+
+	// Gateway host[:port] for "natpmp" providers (default: the default route)
+	Gateway string `toml:"gateway,omitempty"`
+}
+
+// APIConfig controls how Cloudflare API requests are retried and rate limited
+type APIConfig struct {
+	// Attempts per request, including the first one (default: 4, 1 = no retries)
+	MaxAttempts int `toml:"max_attempts,omitempty"`
+
+	// Delay before the first retry in seconds, doubled for each further
+	// retry with random jitter (default: 1)
+	RetryDelay int `toml:"retry_delay,omitempty"`
+
+	// Upper bound for the retry delay in seconds (default: 60)
+	MaxRetryDelay int `toml:"max_retry_delay,omitempty"`
+
+	// Requests allowed per five minutes for each set of credentials
+	// (default: 1200, Cloudflare's own limit)
+	RateLimit int `toml:"rate_limit,omitempty"`
+}
+
+// NotificationsConfig configures where events are sent
+type NotificationsConfig struct {
+	// Consecutive failures of a domain before domain_failed is sent (default: 3)
+	FailureThreshold int `toml:"failure_threshold,omitempty"`
+
+	Notifiers []NotifierConfig `toml:"notifiers,omitempty"`
+}
+
+// NotifierConfig configures a single notification destination
+type NotifierConfig struct {
+	// Notifier type: "webhook", "slack", "discord", "teams", "ntfy", "gotify" or "email"
+	Type string `toml:"type"`
+
+	// Events to send: "record_changed", "domain_failed", "domain_recovered" (default: all)
+	Events []string `toml:"events,omitempty"`
+
+	// Webhook, ntfy topic or Gotify server URL
+	URL string `toml:"url,omitempty"`
+
+	// text/template rendering the body of "webhook" notifiers, or the message
+	// text of the other types
+	Template string `toml:"template,omitempty"`
+
+	// Extra HTTP headers for "webhook" notifiers
+	Headers map[string]string `toml:"headers,omitempty"`
+
+	// Access token for "ntfy" or application token for "gotify"
+	Token string `toml:"token,omitempty"`
+
+	// SMTP settings for "email" notifiers
+	SMTPHost string   `toml:"smtp_host,omitempty"`
+	SMTPPort int      `toml:"smtp_port,omitempty"`
+	Username string   `toml:"username,omitempty"`
+	Password string   `toml:"password,omitempty"`
+	From     string   `toml:"from,omitempty"`
+	To       []string `toml:"to,omitempty"`
+}
+
 // DomainConfig represents a domain to update
 type DomainConfig struct {
 	// Domain name (e.g., "example.com" or "subdomain.example.com")
@@ -67,13 +269,122 @@ type DomainConfig struct {
 
 	// Proxied through Cloudflare (default: false)
 	Proxied bool `toml:"proxied,omitempty"`
+
+	// Zone ID for this domain (optional, overrides the credential zone_id)
+	ZoneID string `toml:"zone_id,omitempty"`
+
+	// Name of a [credentials.<name>] set to use instead of [cloudflare]
+	Credentials string `toml:"credentials,omitempty"`
+
+	// What to do when several records of a type exist for the name:
+	// "update_all" (default), "delete_extras" or "error"
+	Duplicates string `toml:"duplicates,omitempty"`
+
+	// Names of [[wan]] sources to publish one record each for, instead of
+	// the address from [ip_detection]
+	WAN []string `toml:"wan,omitempty"`
+
+	// Interface identifier of another host (e.g. "::1234:5678"), combined
+	// with the detected IPv6 prefix to build the AAAA content for that host
+	IPv6Suffix string `toml:"ipv6_suffix,omitempty"`
+
+	// MAC address of another host, turned into an EUI-64 interface identifier
+	// instead of ipv6_suffix. Only valid with a /64 prefix.
+	IPv6MAC string `toml:"ipv6_mac,omitempty"`
+
+	// Bits of the detected address kept as the prefix (default: 64)
+	IPv6PrefixLength int `toml:"ipv6_prefix_length,omitempty"`
 }
 
+// Duplicate record policies
+const (
+	DuplicatesUpdateAll    = "update_all"
+	DuplicatesDeleteExtras = "delete_extras"
+	DuplicatesError        = "error"
+)
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	// Validate Cloudflare credentials
-	if c.Cloudflare.APIToken == "" && (c.Cloudflare.APIKey == "" || c.Cloudflare.Email == "") {
-		return fmt.Errorf("either api_token or both api_key and email must be provided")
+	// Validate named credential sets
+	for name, creds := range c.Credentials {
+		if !creds.HasCredentials() {
+			return fmt.Errorf("credentials.%s: either api_token or both api_key and email must be provided", name)
+		}
+	}
+
+	// Validate logging options
+	switch strings.ToLower(c.LogFormat) {
+	case "", LogFormatText:
+		c.LogFormat = LogFormatText
+	case LogFormatJSON:
+		c.LogFormat = LogFormatJSON
+	default:
+		return fmt.Errorf("log_format must be 'text' or 'json'")
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.LogMaxSize < 0 || c.LogMaxAge < 0 || c.LogMaxBackups < 0 {
+		return fmt.Errorf("log_max_size, log_max_age and log_max_backups must not be negative")
+	}
+	if c.LogMaxBackups == 0 {
+		c.LogMaxBackups = 5
+	}
+
+	// Set default reconcile interval
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("reconcile_interval must not be negative")
+	}
+	if c.ReconcileInterval == 0 {
+		c.ReconcileInterval = 3600
+	}
+
+	// Validate IP source
+	switch strings.ToLower(c.IPSource) {
+	case "", IPSourceHTTP:
+		c.IPSource = IPSourceHTTP
+	case IPSourceInterface:
+		c.IPSource = IPSourceInterface
+		if c.Interface == "" {
+			return fmt.Errorf("interface is required when ip_source is 'interface'")
+		}
+	case IPSourceDNS:
+		c.IPSource = IPSourceDNS
+	default:
+		return fmt.Errorf("ip_source must be 'http', 'interface' or 'dns'")
+	}
+
+	// Validate IP providers
+	if err := validateIPProviders("ip_detection.ipv4", c.IPDetection.IPv4); err != nil {
+		return err
+	}
+	if err := validateIPProviders("ip_detection.ipv6", c.IPDetection.IPv6); err != nil {
+		return err
+	}
+
+	// Validate WAN sources
+	wans := make(map[string]bool, len(c.WAN))
+	for i := range c.WAN {
+		if err := c.WAN[i].validate(i); err != nil {
+			return err
+		}
+		if wans[c.WAN[i].Name] {
+			return fmt.Errorf("wan[%d]: duplicate name %q", i, c.WAN[i].Name)
+		}
+		wans[c.WAN[i].Name] = true
+	}
+
+	// Validate notifications
+	if err := c.Notifications.validate(); err != nil {
+		return err
+	}
+
+	// Validate API retry and rate limit settings
+	if err := c.API.validate(); err != nil {
+		return err
 	}
 
 	// Validate domains
@@ -86,6 +397,15 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("domain[%d]: name is required", i)
 		}
 
+		// Validate Cloudflare credentials
+		if domain.Credentials != "" {
+			if _, ok := c.Credentials[domain.Credentials]; !ok {
+				return fmt.Errorf("domain[%d]: unknown credentials %q", i, domain.Credentials)
+			}
+		} else if !c.Cloudflare.HasCredentials() {
+			return fmt.Errorf("either api_token or both api_key and email must be provided")
+		}
+
 		// Validate record types
 		recordTypes := strings.ToLower(domain.RecordTypes)
 		if recordTypes == "" {
@@ -101,11 +421,335 @@ func (c *Config) Validate() error {
 		if domain.TTL == 0 {
 			c.Domains[i].TTL = 300
 		}
+
+		// Validate duplicate record policy
+		switch strings.ToLower(domain.Duplicates) {
+		case "", DuplicatesUpdateAll:
+			c.Domains[i].Duplicates = DuplicatesUpdateAll
+		case DuplicatesDeleteExtras:
+			c.Domains[i].Duplicates = DuplicatesDeleteExtras
+		case DuplicatesError:
+			c.Domains[i].Duplicates = DuplicatesError
+		default:
+			return fmt.Errorf("domain[%d]: duplicates must be 'update_all', 'delete_extras' or 'error'", i)
+		}
+
+		// Validate the host address options
+		if err := c.Domains[i].validateIPv6Host(); err != nil {
+			return fmt.Errorf("domain[%d]: %w", i, err)
+		}
+
+		// Validate WAN sources
+		for _, wan := range domain.WAN {
+			if !wans[wan] {
+				return fmt.Errorf("domain[%d]: unknown wan %q", i, wan)
+			}
+		}
+	}
+
+	// Validate IP detection modes; the quorum is only checked for the
+	// address families some domain needs
+	ipv4, ipv6 := 0, 0
+	if c.UsesDetection("A") {
+		ipv4 = len(c.IPv4Providers())
+	}
+	if c.UsesDetection("AAAA") {
+		ipv6 = len(c.IPv6Providers())
+	}
+	if err := c.IPDetection.validate("ip_detection", ipv4, ipv6); err != nil {
+		return err
+	}
+
+	for i, wan := range c.WAN {
+		ipv4, ipv6 := 0, 0
+		if c.UsesWAN(wan.Name, "A") {
+			ipv4 = len(wan.IPv4)
+		}
+		if c.UsesWAN(wan.Name, "AAAA") {
+			ipv6 = len(wan.IPv6)
+		}
+		if err := c.WAN[i].IPDetectionConfig.validate(fmt.Sprintf("wan[%d]", i), ipv4, ipv6); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the detection mode, the quorum against the number of IPv4
+// and IPv6 providers in use (0 = family not used), and the source address
+// and interface
+func (d *IPDetectionConfig) validate(section string, ipv4, ipv6 int) error {
+	if d.SourceAddress != "" && net.ParseIP(d.SourceAddress) == nil {
+		return fmt.Errorf("%s.source_address must be an IP address", section)
+	}
+	if d.Interface != "" {
+		if _, err := bindControl(d.Interface); err != nil {
+			return fmt.Errorf("%s.interface: %w", section, err)
+		}
+	}
+
+	switch strings.ToLower(d.Mode) {
+	case "", DetectionModeFirst:
+		d.Mode = DetectionModeFirst
+	case DetectionModeConsensus:
+		d.Mode = DetectionModeConsensus
+		if d.Quorum < 0 {
+			return fmt.Errorf("%s.quorum must not be negative", section)
+		}
+		if ipv4 > 0 && d.Quorum > ipv4 {
+			return fmt.Errorf("%s.quorum (%d) exceeds the number of ipv4 providers (%d)", section, d.Quorum, ipv4)
+		}
+		if ipv6 > 0 && d.Quorum > ipv6 {
+			return fmt.Errorf("%s.quorum (%d) exceeds the number of ipv6 providers (%d)", section, d.Quorum, ipv6)
+		}
+	default:
+		return fmt.Errorf("%s.mode must be 'first' or 'consensus'", section)
+	}
+	return nil
+}
+
+// validateIPv6Host checks the options building an AAAA record for another
+// host from the detected prefix and applies defaults
+func (d *DomainConfig) validateIPv6Host() error {
+	if d.IPv6Suffix == "" && d.IPv6MAC == "" {
+		if d.IPv6PrefixLength != 0 {
+			return fmt.Errorf("ipv6_prefix_length requires ipv6_suffix or ipv6_mac")
+		}
+		return nil
+	}
+	if d.IPv6Suffix != "" && d.IPv6MAC != "" {
+		return fmt.Errorf("ipv6_suffix and ipv6_mac cannot both be set")
+	}
+	if !d.ShouldUpdateAAAA() {
+		return fmt.Errorf("ipv6_suffix and ipv6_mac require AAAA records")
+	}
+
+	if d.IPv6PrefixLength == 0 {
+		d.IPv6PrefixLength = 64
+	}
+	if d.IPv6PrefixLength < 1 || d.IPv6PrefixLength > 127 {
+		return fmt.Errorf("ipv6_prefix_length must be between 1 and 127")
+	}
+
+	if d.IPv6MAC != "" {
+		if mac, err := net.ParseMAC(d.IPv6MAC); err != nil || len(mac) != 6 {
+			return fmt.Errorf("ipv6_mac must be a 48-bit MAC address")
+		}
+		// An EUI-64 identifier fills exactly the lower 64 bits, so a shorter
+		// prefix would always publish subnet 0
+		if d.IPv6PrefixLength != 64 {
+			return fmt.Errorf("ipv6_prefix_length must be 64 with ipv6_mac, use ipv6_suffix to pick another subnet")
+		}
+	} else if ip := net.ParseIP(d.IPv6Suffix); ip == nil || ip.To4() != nil {
+		return fmt.Errorf("ipv6_suffix must be an IPv6 address such as ::1234:5678")
+	}
+
+	// The identifier must fit in the bits the prefix leaves over
+	mask := net.CIDRMask(d.IPv6PrefixLength, 128)
+	for i, b := range d.interfaceID() {
+		if b&mask[i] != 0 {
+			return fmt.Errorf("interface identifier has bits inside the /%d prefix", d.IPv6PrefixLength)
+		}
+	}
+	return nil
+}
+
+// validate checks a WAN source has a name and valid providers
+func (w *WANConfig) validate(i int) error {
+	section := fmt.Sprintf("wan[%d]", i)
+	if w.Name == "" {
+		return fmt.Errorf("%s: name is required", section)
+	}
+	if len(w.IPv4) == 0 && len(w.IPv6) == 0 {
+		return fmt.Errorf("%s: at least one ipv4 or ipv6 provider is required", section)
+	}
+	if err := validateIPProviders(section+".ipv4", w.IPv4); err != nil {
+		return err
+	}
+	return validateIPProviders(section+".ipv6", w.IPv6)
+}
+
+// validate checks the retry and rate limit settings and applies defaults
+func (a *APIConfig) validate() error {
+	if a.MaxAttempts < 0 || a.RetryDelay < 0 || a.MaxRetryDelay < 0 || a.RateLimit < 0 {
+		return fmt.Errorf("api settings must not be negative")
+	}
+	if a.MaxAttempts == 0 {
+		a.MaxAttempts = 4
+	}
+	if a.RetryDelay == 0 {
+		a.RetryDelay = 1
+	}
+	if a.MaxRetryDelay == 0 {
+		a.MaxRetryDelay = 60
+	}
+	if a.MaxRetryDelay < a.RetryDelay {
+		return fmt.Errorf("api.max_retry_delay must not be less than api.retry_delay")
+	}
+	if a.RateLimit == 0 {
+		a.RateLimit = 1200
+	}
+	return nil
+}
+
+// validate checks each notifier has the settings its type needs
+func (n *NotificationsConfig) validate() error {
+	if n.FailureThreshold < 0 {
+		return fmt.Errorf("notifications.failure_threshold must not be negative")
+	}
+	if n.FailureThreshold == 0 {
+		n.FailureThreshold = 3
+	}
+
+	for i, notifier := range n.Notifiers {
+		notifierType := strings.ToLower(notifier.Type)
+		n.Notifiers[i].Type = notifierType
+
+		for _, event := range notifier.Events {
+			if event != EventRecordChanged && event != EventDomainFailed && event != EventDomainRecovered {
+				return fmt.Errorf("notifications.notifiers[%d]: unknown event %q", i, event)
+			}
+		}
+
+		switch notifierType {
+		case NotifierWebhook, NotifierSlack, NotifierDiscord, NotifierTeams, NotifierNtfy:
+			if notifier.URL == "" {
+				return fmt.Errorf("notifications.notifiers[%d]: url is required", i)
+			}
+		case NotifierGotify:
+			if notifier.URL == "" || notifier.Token == "" {
+				return fmt.Errorf("notifications.notifiers[%d]: url and token are required", i)
+			}
+		case NotifierEmail:
+			if notifier.SMTPHost == "" || notifier.From == "" || len(notifier.To) == 0 {
+				return fmt.Errorf("notifications.notifiers[%d]: smtp_host, from and to are required", i)
+			}
+			if notifier.SMTPPort == 0 {
+				n.Notifiers[i].SMTPPort = 587
+			}
+		default:
+			return fmt.Errorf("notifications.notifiers[%d]: type must be 'webhook', 'slack', 'discord', 'teams', 'ntfy', 'gotify' or 'email'", i)
+		}
+
+		if notifier.Template != "" {
+			if _, err := parseNotificationTemplate(notifier.Template); err != nil {
+				return fmt.Errorf("notifications.notifiers[%d]: invalid template: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateIPProviders checks each provider has the settings its type needs
+func validateIPProviders(section string, providers []IPProviderConfig) error {
+	for i, provider := range providers {
+		providerType := strings.ToLower(provider.Type)
+		if providerType == "" {
+			providerType = ProviderHTTP
+		}
+		providers[i].Type = providerType
+
+		switch providerType {
+		case ProviderHTTP:
+			if provider.URL == "" {
+				return fmt.Errorf("%s[%d]: url is required", section, i)
+			}
+		case ProviderHTTPJSON:
+			if provider.URL == "" || provider.Field == "" {
+				return fmt.Errorf("%s[%d]: url and field are required", section, i)
+			}
+		case ProviderInterface:
+			if provider.Interface == "" {
+				return fmt.Errorf("%s[%d]: interface is required", section, i)
+			}
+		case ProviderCommand:
+			if len(provider.Command) == 0 {
+				return fmt.Errorf("%s[%d]: command is required", section, i)
+			}
+		case ProviderDNS:
+			if err := validateDNSProvider(&providers[i]); err != nil {
+				return fmt.Errorf("%s[%d]: %w", section, i, err)
+			}
+		case ProviderUPnP, ProviderNATPMP:
+			if strings.HasSuffix(section, "ipv6") {
+				return fmt.Errorf("%s[%d]: %s only provides IPv4 addresses", section, i, providerType)
+			}
+		default:
+			return fmt.Errorf("%s[%d]: type must be 'http', 'http_json', 'interface', 'command', 'dns', 'upnp' or 'natpmp'", section, i)
+		}
+	}
+	return nil
+}
+
+// validateDNSProvider fills in defaults for a "dns" provider
+func validateDNSProvider(provider *IPProviderConfig) error {
+	provider.Class = strings.ToUpper(provider.Class)
+	if provider.Class == "" {
+		provider.Class = "IN"
+	}
+	if provider.Class != "IN" && provider.Class != "CH" {
+		return fmt.Errorf("class must be 'IN' or 'CH'")
+	}
+
+	provider.RecordType = strings.ToUpper(provider.RecordType)
+	if provider.RecordType == "" || provider.RecordType == "AAAA" {
+		provider.RecordType = "A"
+	}
+	if provider.Class == "CH" {
+		provider.RecordType = "TXT"
+	}
+	if provider.RecordType != "A" && provider.RecordType != "TXT" {
+		return fmt.Errorf("record_type must be 'A' or 'TXT'")
+	}
+
+	// The OpenDNS defaults only answer the plain address lookup, so any
+	// other query must name its resolver and query explicitly
+	if provider.Resolver == "" && provider.Query == "" && provider.Class == "IN" && provider.RecordType == "A" {
+		provider.Resolver = defaultDNSResolver
+		provider.Query = defaultDNSQuery
+	}
+	if provider.Resolver == "" || provider.Query == "" {
+		return fmt.Errorf("resolver and query are required unless both are left out for the OpenDNS address lookup")
 	}
 
 	return nil
 }
 
+// IPv4Providers returns the IPv4 providers to use, falling back to ip_source
+func (c *Config) IPv4Providers() []IPProviderConfig {
+	if len(c.IPDetection.IPv4) > 0 {
+		return c.IPDetection.IPv4
+	}
+	return c.defaultProviders(defaultIPv4Services)
+}
+
+// IPv6Providers returns the IPv6 providers to use, falling back to ip_source
+func (c *Config) IPv6Providers() []IPProviderConfig {
+	if len(c.IPDetection.IPv6) > 0 {
+		return c.IPDetection.IPv6
+	}
+	return c.defaultProviders(defaultIPv6Services)
+}
+
+// defaultProviders builds the provider list implied by ip_source
+func (c *Config) defaultProviders(services []string) []IPProviderConfig {
+	switch c.IPSource {
+	case IPSourceInterface:
+		return []IPProviderConfig{{Type: ProviderInterface, Interface: c.Interface}}
+	case IPSourceDNS:
+		provider := IPProviderConfig{Type: ProviderDNS}
+		validateDNSProvider(&provider)
+		return []IPProviderConfig{provider}
+	}
+
+	providers := make([]IPProviderConfig, 0, len(services))
+	for _, service := range services {
+		providers = append(providers, IPProviderConfig{Type: ProviderHTTP, URL: service})
+	}
+	return providers
+}
+
 // ShouldUpdateA returns true if A records should be updated for this domain
 func (d *DomainConfig) ShouldUpdateA() bool {
 	recordTypes := strings.ToLower(d.RecordTypes)
@@ -118,6 +762,47 @@ func (d *DomainConfig) ShouldUpdateAAAA() bool {
 	return recordTypes == "aaaa" || recordTypes == "both"
 }
 
+// UsesWAN returns true if the domain publishes one record per WAN source
+func (d *DomainConfig) UsesWAN() bool {
+	return len(d.WAN) > 0
+}
+
+// UsesDetection returns true if a domain without WAN sources publishes a
+// record of the type, so [ip_detection] must detect its address family
+func (c *Config) UsesDetection(recordType string) bool {
+	for _, domain := range c.Domains {
+		if domain.UsesWAN() {
+			continue
+		}
+		if (recordType == "A" && domain.ShouldUpdateA()) || (recordType == "AAAA" && domain.ShouldUpdateAAAA()) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsesWAN returns true if a domain publishes a record for the named WAN
+// source and record type
+func (c *Config) UsesWAN(name, recordType string) bool {
+	for _, domain := range c.Domains {
+		if !slices.Contains(domain.WAN, name) {
+			continue
+		}
+		if (recordType == "A" && domain.ShouldUpdateA()) || (recordType == "AAAA" && domain.ShouldUpdateAAAA()) {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialsFor returns the Cloudflare credentials used by a domain
+func (c *Config) CredentialsFor(domain DomainConfig) CloudflareConfig {
+	if domain.Credentials != "" {
+		return c.Credentials[domain.Credentials]
+	}
+	return c.Cloudflare
+}
+
 // LoadConfigFromFile loads configuration from TOML file
 func LoadConfigFromFile(filename string) (*Config, error) {
 	var config Config
@@ -0,0 +1,206 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// staleGrace is added to twice the interval before a cycle counts as overdue
+const staleGrace = time.Minute
+
+// Health tracks the outcome of update cycles for the health endpoints
+type Health struct {
+	mu sync.Mutex
+
+	started     time.Time
+	interval    time.Duration
+	lastCycle   time.Time
+	lastSuccess time.Time
+	lastError   string
+	ipv4        string
+	ipv6        string
+	domains     map[string]*domainHealth
+}
+
+// domainHealth is the last known status of a single domain
+type domainHealth struct {
+	OK          bool       `json:"ok"`
+	LastChecked time.Time  `json:"last_checked"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// healthReport is the JSON document served by /healthz and /readyz
+type healthReport struct {
+	Status          string                   `json:"status"`
+	LastCycle       *time.Time               `json:"last_cycle,omitempty"`
+	LastSuccess     *time.Time               `json:"last_success,omitempty"`
+	LastError       string                   `json:"last_error,omitempty"`
+	SecondsSince    float64                  `json:"seconds_since_last_cycle"`
+	IntervalSeconds float64                  `json:"interval_seconds"`
+	Stale           bool                     `json:"stale"`
+	IPv4            string                   `json:"ipv4,omitempty"`
+	IPv6            string                   `json:"ipv6,omitempty"`
+	Domains         map[string]*domainHealth `json:"domains"`
+}
+
+// NewHealth creates a health tracker for an updater running every interval
+func NewHealth(interval time.Duration) *Health {
+	return &Health{
+		started:  time.Now(),
+		interval: interval,
+		domains:  make(map[string]*domainHealth),
+	}
+}
+
+// RecordIPs stores the addresses detected in the current cycle
+func (h *Health) RecordIPs(ipv4, ipv6 string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ipv4, h.ipv6 = ipv4, ipv6
+}
+
+// RecordDomain stores the outcome of updating a domain
+func (h *Health) RecordDomain(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status, ok := h.domains[name]
+	if !ok {
+		status = &domainHealth{}
+		h.domains[name] = status
+	}
+
+	status.LastChecked = time.Now()
+	status.OK = err == nil
+	if err != nil {
+		status.LastError = err.Error()
+		return
+	}
+	lastSuccess := status.LastChecked
+	status.LastSuccess = &lastSuccess
+	status.LastError = ""
+}
+
+// RecordCycle stores the outcome of a complete update cycle. Domains that
+// are no longer configured are dropped from the report.
+func (h *Health) RecordCycle(err error, interval time.Duration, domains []DomainConfig) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.interval = interval
+	h.lastCycle = time.Now()
+	if err != nil {
+		h.lastError = err.Error()
+	} else {
+		h.lastSuccess = h.lastCycle
+		h.lastError = ""
+	}
+
+	configured := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		configured[domain.Name] = true
+	}
+	for name := range h.domains {
+		if !configured[name] {
+			delete(h.domains, name)
+		}
+	}
+}
+
+// report builds the current health report. live is false when no cycle
+// finished within the stale window; ready additionally requires the last
+// cycle to have succeeded.
+func (h *Health) report() (report healthReport, live, ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	reference := h.lastCycle
+	if reference.IsZero() {
+		reference = h.started
+	}
+	since := now.Sub(reference)
+	stale := since > 2*h.interval+staleGrace
+
+	report = healthReport{
+		LastError:       h.lastError,
+		SecondsSince:    since.Seconds(),
+		IntervalSeconds: h.interval.Seconds(),
+		Stale:           stale,
+		IPv4:            h.ipv4,
+		IPv6:            h.ipv6,
+		Domains:         make(map[string]*domainHealth, len(h.domains)),
+	}
+	if !h.lastCycle.IsZero() {
+		lastCycle := h.lastCycle
+		report.LastCycle = &lastCycle
+	}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		report.LastSuccess = &lastSuccess
+	}
+
+	names := make([]string, 0, len(h.domains))
+	for name := range h.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status := *h.domains[name]
+		report.Domains[name] = &status
+	}
+
+	live = !stale
+	ready = live && !h.lastCycle.IsZero() && h.lastError == ""
+	return report, live, ready
+}
+
+// ServeLiveness handles /healthz: it fails only when the updater appears stuck
+func (h *Health) ServeLiveness(w http.ResponseWriter, r *http.Request) {
+	report, live, _ := h.report()
+	writeHealth(w, report, live)
+}
+
+// ServeReadiness handles /readyz: it fails until a cycle has succeeded and
+// whenever the last cycle failed or is overdue
+func (h *Health) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	report, _, ready := h.report()
+	writeHealth(w, report, ready)
+}
+
+// writeHealth writes the report with 200 when ok and 503 otherwise
+func writeHealth(w http.ResponseWriter, report healthReport, ok bool) {
+	status := http.StatusOK
+	report.Status = "ok"
+	if !ok {
+		status = http.StatusServiceUnavailable
+		report.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -166,7 +166,7 @@ create_user() {
     local cf_ddns_log_dir="$LOG_DIR/cf-ddns-updater"
     mkdir -p "$cf_ddns_log_dir"
     chown cf-ddns:cf-ddns "$cf_ddns_log_dir"
-    chmod 755 "$cf_ddns_log_dir"
+    chmod 750 "$cf_ddns_log_dir"
     
     log_success "User and permissions configured"
 }
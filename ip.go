@@ -19,82 +19,227 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// IP address sources
+const (
+	IPSourceHTTP      = "http"
+	IPSourceInterface = "interface"
+	IPSourceDNS       = "dns"
+)
+
+// IP detection modes
+const (
+	DetectionModeFirst     = "first"
+	DetectionModeConsensus = "consensus"
+)
+
+// Default services for IP detection, with fetch-ip.com first
+var (
+	defaultIPv4Services = []string{
+		"https://v4.fetch-ip.com",
+		"https://ipv4.icanhazip.com",
+		"https://api.ipify.org",
+		"https://ipv4.ident.me",
+		"https://v4.ident.me",
+	}
+
+	defaultIPv6Services = []string{
+		"https://v6.fetch-ip.com",
+		"https://ipv6.icanhazip.com",
+		"https://api6.ipify.org",
+		"https://ipv6.ident.me",
+		"https://v6.ident.me",
+	}
+)
+
 // IPDetector handles IP address detection
 type IPDetector struct {
-	client *http.Client
+	ipv4Providers []IPProvider
+	ipv6Providers []IPProvider
+
+	// mode is DetectionModeFirst or DetectionModeConsensus
+	mode string
+
+	// quorum is the number of agreeing providers needed in consensus mode,
+	// 0 meaning a majority
+	quorum int
+}
+
+// NewIPDetector creates a new IP detector using the configured providers
+func NewIPDetector(config *Config) *IPDetector {
+	return newIPDetector(config.IPDetection, config.IPv4Providers(), config.IPv6Providers())
+}
+
+// NewWANDetector creates an IP detector for a single WAN source
+func NewWANDetector(wan WANConfig) *IPDetector {
+	return newIPDetector(wan.IPDetectionConfig, wan.IPv4, wan.IPv6)
 }
 
-// NewIPDetector creates a new IP detector
-func NewIPDetector() *IPDetector {
+// newIPDetector creates an IP detector for the given providers
+func newIPDetector(detection IPDetectionConfig, ipv4, ipv6 []IPProviderConfig) *IPDetector {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	if detection.SourceAddress != "" || detection.Interface != "" {
+		client.Transport = newBoundTransport(detection.SourceAddress, detection.Interface)
+	}
+
 	return &IPDetector{
-		client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		ipv4Providers: newIPProviders(ipv4, client),
+		ipv6Providers: newIPProviders(ipv6, client),
+		mode:          detection.Mode,
+		quorum:        detection.Quorum,
 	}
 }
 
 // GetIPv4 retrieves the current public IPv4 address
-func (d *IPDetector) GetIPv4() (string, error) {
-	// Try multiple services for reliability, with fetch-ip.com as default
-	services := []string{
-		"https://v4.fetch-ip.com",
-		"https://ipv4.icanhazip.com",
-		"https://api.ipify.org",
-		"https://ipv4.ident.me",
-		"https://v4.ident.me",
+func (d *IPDetector) GetIPv4(ctx context.Context) (string, error) {
+	return d.getIP(ctx, d.ipv4Providers, false)
+}
+
+// GetIPv6 retrieves the current public IPv6 address
+func (d *IPDetector) GetIPv6(ctx context.Context) (string, error) {
+	return d.getIP(ctx, d.ipv6Providers, true)
+}
+
+// getIP detects an address using the configured mode
+func (d *IPDetector) getIP(ctx context.Context, providers []IPProvider, ipv6 bool) (string, error) {
+	if d.mode == DetectionModeConsensus {
+		return d.getIPConsensus(ctx, providers, ipv6)
 	}
+	return d.getIPFirst(ctx, providers, ipv6)
+}
 
-	for _, service := range services {
-		ip, err := d.getIPFromService(service)
-		if err == nil && isValidIPv4(ip) {
+// getIPFirst tries each provider in order until one returns a valid address
+func (d *IPDetector) getIPFirst(ctx context.Context, providers []IPProvider, ipv6 bool) (string, error) {
+	family, isValid := familyValidator(ipv6)
+
+	var lastErr error
+	for _, provider := range providers {
+		ip, err := provider.GetIP(ctx, ipv6)
+		if err == nil && isValid(ip) {
+			metrics.ObserveProvider(family, provider.Name(), true)
 			return ip, nil
 		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		metrics.ObserveProvider(family, provider.Name(), false)
+
+		if err == nil {
+			err = fmt.Errorf("invalid %s address %q", family, ip)
+		}
+		lastErr = fmt.Errorf("%s: %w", provider.Name(), err)
+	}
+
+	if lastErr != nil {
+		return "", fmt.Errorf("failed to get %s address from all providers (last error: %v)", family, lastErr)
 	}
+	return "", fmt.Errorf("no %s providers configured", family)
+}
 
-	return "", fmt.Errorf("failed to get IPv4 address from all services")
+// providerResult is the answer of a single provider in consensus mode
+type providerResult struct {
+	provider IPProvider
+	ip       string
+	err      error
 }
 
-// GetIPv6 retrieves the current public IPv6 address
-func (d *IPDetector) GetIPv6() (string, error) {
-	// Try multiple services for reliability, with fetch-ip.com as default
-	services := []string{
-		"https://v6.fetch-ip.com",
-		"https://ipv6.icanhazip.com",
-		"https://api6.ipify.org",
-		"https://ipv6.ident.me",
-		"https://v6.ident.me",
+// getIPConsensus queries all providers concurrently and only accepts an
+// address reported by at least quorum of them
+func (d *IPDetector) getIPConsensus(ctx context.Context, providers []IPProvider, ipv6 bool) (string, error) {
+	family, isValid := familyValidator(ipv6)
+	if len(providers) == 0 {
+		return "", fmt.Errorf("no %s providers configured", family)
 	}
 
-	for _, service := range services {
-		ip, err := d.getIPFromService(service)
-		if err == nil && isValidIPv6(ip) {
-			return ip, nil
+	quorum := d.quorum
+	if quorum <= 0 {
+		quorum = len(providers)/2 + 1
+	}
+
+	results := make(chan providerResult, len(providers))
+	for _, provider := range providers {
+		go func(provider IPProvider) {
+			ip, err := provider.GetIP(ctx, ipv6)
+			if err == nil && !isValid(ip) {
+				err = fmt.Errorf("invalid %s address %q", family, ip)
+			}
+			results <- providerResult{provider: provider, ip: normalizeIP(ip), err: err}
+		}(provider)
+	}
+
+	answers := make([]providerResult, 0, len(providers))
+	votes := make(map[string]int)
+	for range providers {
+		result := <-results
+		answers = append(answers, result)
+		if result.err == nil {
+			votes[result.ip]++
+		}
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	for _, result := range answers {
+		metrics.ObserveProvider(family, result.provider.Name(), result.err == nil)
+	}
+
+	winner, best := "", 0
+	for ip, count := range votes {
+		if count > best || (count == best && ip < winner) {
+			winner, best = ip, count
+		}
+	}
+
+	// Report every provider that failed or disagreed with the leading answer
+	for _, result := range answers {
+		switch {
+		case result.err != nil:
+			slog.Warn("IP provider failed", "family", family, "provider", result.provider.Name(), "error", result.err)
+		case result.ip != winner:
+			slog.Warn("IP provider disagrees with leading answer", "family", family, "provider", result.provider.Name(),
+				"reported", result.ip, "leading", winner)
 		}
 	}
 
-	return "", fmt.Errorf("failed to get IPv6 address from all services")
+	if best < quorum {
+		return "", fmt.Errorf("no %s address reached quorum (%d of %d providers needed, best answer %q had %d)",
+			family, quorum, len(providers), winner, best)
+	}
+
+	return winner, nil
 }
 
-// getIPFromService fetches IP from a specific service
-func (d *IPDetector) getIPFromService(url string) (string, error) {
-	resp, err := d.client.Get(url)
-	if err != nil {
-		return "", err
+// familyValidator returns the family name and validation function
+func familyValidator(ipv6 bool) (string, func(string) bool) {
+	if ipv6 {
+		return "IPv6", isValidIPv6
 	}
-	defer resp.Body.Close()
+	return "IPv4", isValidIPv4
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+// normalizeIP returns the canonical text form of an address so equivalent
+// IPv6 spellings compare equal
+func normalizeIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
 	}
+	return ip
+}
 
-	body, err := io.ReadAll(resp.Body)
+// getIPFromService fetches IP from a specific service
+func getIPFromService(ctx context.Context, client *http.Client, url string) (string, error) {
+	body, err := fetchURL(ctx, client, url)
 	if err != nil {
 		return "", err
 	}
@@ -103,6 +248,27 @@ func (d *IPDetector) getIPFromService(url string) (string, error) {
 	return ip, nil
 }
 
+// fetchURL performs a GET request and returns the response body
+func fetchURL(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	}
+
+	// IP services answer with a few bytes; cap the read to stay safe
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
 // isValidIPv4 checks if the string is a valid IPv4 address
 func isValidIPv4(ip string) bool {
 	parts := strings.Split(ip, ".")
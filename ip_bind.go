@@ -0,0 +1,46 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// newBoundTransport creates an HTTP transport whose connections leave from a
+// local address and/or through a network interface, so each uplink of a
+// multi-WAN host can be asked for its own public address. Both settings must
+// already be validated.
+func newBoundTransport(sourceAddress, iface string) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if sourceAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(sourceAddress)}
+	}
+	if iface != "" {
+		dialer.Control, _ = bindControl(iface)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindControl returns a dialer control function binding sockets to the named
+// interface with SO_BINDTODEVICE, so traffic leaves through it regardless of
+// the routing table. Kernels before 5.7 require CAP_NET_RAW for this.
+func bindControl(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		if bindErr != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", iface, bindErr)
+		}
+		return nil
+	}, nil
+}
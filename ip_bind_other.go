@@ -0,0 +1,32 @@
+//go:build !linux
+
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindControl is not supported on this platform; source_address can be used
+// to pick the uplink instead
+func bindControl(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, fmt.Errorf("binding to an interface is only supported on Linux, use source_address instead")
+}
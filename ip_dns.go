@@ -0,0 +1,316 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DNS provider defaults (OpenDNS answers myip.opendns.com with the client address)
+const (
+	defaultDNSResolver = "resolver1.opendns.com"
+	defaultDNSQuery    = "myip.opendns.com"
+	dnsTimeout         = 5 * time.Second
+)
+
+// DNS record types and classes used by the DNS provider
+const (
+	dnsTypeTXT   = 16
+	dnsClassIN   = 1
+	dnsClassCH   = 3
+	dnsMaxPacket = 1232
+)
+
+// newDNSResolver creates a resolver with a dial timeout. If server is set,
+// every query goes to it, and family ("4" or "6") restricts both UDP and
+// the TCP fallback for truncated answers to that address family, so the
+// answer reflects it.
+func newDNSResolver(server, family string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{
+				Timeout: dnsTimeout,
+			}
+			if server != "" {
+				address = server
+			}
+			if family != "" {
+				network = strings.TrimRight(network, "46") + family
+			}
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// dnsProvider asks a DNS resolver for the address it sees the query coming from
+type dnsProvider struct {
+	resolver   string
+	query      string
+	class      string
+	recordType string
+}
+
+// Name returns the query and resolver
+func (p *dnsProvider) Name() string {
+	return fmt.Sprintf("dns %s %s @%s", p.class, p.query, p.resolver)
+}
+
+// GetIP queries the resolver over IPv4 or IPv6 depending on the requested family
+func (p *dnsProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	family := "4"
+	if ipv6 {
+		family = "6"
+	}
+	server := withDefaultPort(p.resolver, "53")
+
+	if p.class == "CH" {
+		return queryTXT(ctx, family, server, p.query, dnsClassCH)
+	}
+
+	resolver := newDNSResolver(server, family)
+	if p.recordType == "TXT" {
+		records, err := resolver.LookupTXT(ctx, p.query)
+		if err != nil {
+			return "", err
+		}
+		if len(records) == 0 {
+			return "", fmt.Errorf("no TXT records for %s", p.query)
+		}
+		return strings.TrimSpace(records[0]), nil
+	}
+
+	ips, err := resolver.LookupIP(ctx, "ip"+family, p.query)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no addresses for %s", p.query)
+	}
+	return ips[0].String(), nil
+}
+
+// withDefaultPort appends port to host if it doesn't already specify one
+func withDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// queryTXT sends a single TXT query with the given class over the address
+// family ("4" or "6") and returns the first TXT string of the answer.
+// net.Resolver only supports the IN class, so queries such as
+// "whoami.cloudflare" in the CH class are built by hand. A truncated UDP
+// answer is retried over TCP.
+func queryTXT(ctx context.Context, family, server, name string, class uint16) (string, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate query ID: %w", err)
+	}
+
+	query, err := buildDNSQuery(binary.BigEndian.Uint16(id[:]), name, dnsTypeTXT, class)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := dnsExchange(ctx, "udp"+family, server, query)
+	if err == nil && len(resp) > 2 && resp[2]&0x02 != 0 {
+		resp, err = dnsExchange(ctx, "tcp"+family, server, query)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return parseTXTResponse(resp, binary.BigEndian.Uint16(id[:]))
+}
+
+// dnsExchange sends a DNS query and reads the response, framing both with a
+// length prefix over TCP
+func dnsExchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	d := net.Dialer{Timeout: dnsTimeout}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(dnsTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	// Abort the exchange when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	stream := strings.HasPrefix(network, "tcp")
+	if stream {
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send DNS query: %w", err)
+	}
+
+	resp, err := readDNSResponse(conn, stream)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	return resp, nil
+}
+
+// readDNSResponse reads one DNS message from a datagram or, for streams, a
+// length-prefixed message
+func readDNSResponse(conn net.Conn, stream bool) ([]byte, error) {
+	if !stream {
+		buf := make([]byte, dnsMaxPacket)
+		n, err := conn.Read(buf)
+		return buf[:n], err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err := io.ReadFull(conn, buf)
+	return buf, err
+}
+
+// buildDNSQuery encodes a DNS query message with recursion desired
+func buildDNSQuery(id uint16, name string, qtype, qclass uint16) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNS name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, qclass)
+
+	return msg, nil
+}
+
+// errShortDNSMessage is returned when a DNS response is truncated or malformed
+var errShortDNSMessage = errors.New("malformed DNS response")
+
+// parseTXTResponse returns the first TXT string in a DNS response
+func parseTXTResponse(msg []byte, id uint16) (string, error) {
+	if len(msg) < 12 {
+		return "", errShortDNSMessage
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return "", fmt.Errorf("DNS response ID mismatch")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		return "", fmt.Errorf("DNS query failed with rcode %d", rcode)
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < qdcount; i++ {
+		next, err := skipDNSName(msg, offset)
+		if err != nil {
+			return "", err
+		}
+		offset = next + 4 // QTYPE + QCLASS
+		if offset > len(msg) {
+			return "", errShortDNSMessage
+		}
+	}
+
+	for i := 0; i < ancount; i++ {
+		next, err := skipDNSName(msg, offset)
+		if err != nil {
+			return "", err
+		}
+		if next+10 > len(msg) {
+			return "", errShortDNSMessage
+		}
+
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		rdlength := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		if rdata+rdlength > len(msg) {
+			return "", errShortDNSMessage
+		}
+		offset = rdata + rdlength
+
+		if rtype != dnsTypeTXT || rdlength == 0 {
+			continue
+		}
+
+		// TXT RDATA is one or more length-prefixed strings
+		var txt strings.Builder
+		for pos := rdata; pos < offset; {
+			length := int(msg[pos])
+			if pos+1+length > offset {
+				return "", errShortDNSMessage
+			}
+			txt.Write(msg[pos+1 : pos+1+length])
+			pos += 1 + length
+		}
+		return strings.Trim(strings.TrimSpace(txt.String()), `"`), nil
+	}
+
+	return "", fmt.Errorf("no TXT record in DNS response")
+}
+
+// skipDNSName returns the offset just past the (possibly compressed) name at offset
+func skipDNSName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errShortDNSMessage
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			// A compression pointer ends the name
+			if offset+2 > len(msg) {
+				return 0, errShortDNSMessage
+			}
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
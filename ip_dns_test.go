@@ -0,0 +1,309 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// dnsHeader builds a response header with the given ID, flags and counts
+func dnsHeader(id, flags uint16, qdcount, ancount int) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, flags)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(qdcount))
+	msg = binary.BigEndian.AppendUint16(msg, uint16(ancount))
+	return append(msg, 0, 0, 0, 0) // NSCOUNT, ARCOUNT
+}
+
+// dnsQuestion encodes a question for whoami.cloudflare TXT in class
+func dnsQuestion(class uint16) []byte {
+	q := []byte("\x06whoami\x0acloudflare\x00")
+	q = binary.BigEndian.AppendUint16(q, dnsTypeTXT)
+	return binary.BigEndian.AppendUint16(q, class)
+}
+
+// dnsRecord encodes a resource record with an already encoded name
+func dnsRecord(name []byte, rtype, class uint16, rdata []byte) []byte {
+	rr := append([]byte(nil), name...)
+	rr = binary.BigEndian.AppendUint16(rr, rtype)
+	rr = binary.BigEndian.AppendUint16(rr, class)
+	rr = binary.BigEndian.AppendUint32(rr, 60)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+	return append(rr, rdata...)
+}
+
+// txtData encodes TXT RDATA from its character strings
+func txtData(strs ...string) []byte {
+	var rdata []byte
+	for _, s := range strs {
+		rdata = append(rdata, byte(len(s)))
+		rdata = append(rdata, s...)
+	}
+	return rdata
+}
+
+// pointerToQuestion is a compression pointer to the name at offset 12
+var pointerToQuestion = []byte{0xc0, 12}
+
+// concat joins message parts
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		qname   string
+		class   uint16
+		want    []byte
+		wantErr bool
+	}{
+		{"CH", "whoami.cloudflare", dnsClassCH, concat(dnsHeader(0x1234, 0x0100, 1, 0), dnsQuestion(dnsClassCH)), false},
+		{"IN", "whoami.cloudflare.", dnsClassIN, concat(dnsHeader(0x1234, 0x0100, 1, 0), dnsQuestion(dnsClassIN)), false},
+		{"empty label", "whoami..cloudflare", dnsClassIN, nil, true},
+		{"long label", string(bytes.Repeat([]byte("a"), 64)) + ".com", dnsClassIN, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildDNSQuery(0x1234, tt.qname, dnsTypeTXT, tt.class)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("query = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTXTResponse(t *testing.T) {
+	const id = 0xbeef
+	question := dnsQuestion(dnsClassCH)
+	answer := dnsRecord(pointerToQuestion, dnsTypeTXT, dnsClassCH, txtData("203.0.113.9"))
+	ok := concat(dnsHeader(id, 0x8180, 1, 1), question, answer)
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		want    string
+		wantErr string
+	}{
+		{"compressed name", ok, "203.0.113.9", ""},
+		{"uncompressed name", concat(dnsHeader(id, 0x8180, 1, 1), question,
+			dnsRecord([]byte("\x06whoami\x0acloudflare\x00"), dnsTypeTXT, dnsClassCH, txtData("203.0.113.9"))), "203.0.113.9", ""},
+		{"label then pointer", concat(dnsHeader(id, 0x8180, 1, 1), question,
+			dnsRecord([]byte("\x03sub\xc0\x0c"), dnsTypeTXT, dnsClassCH, txtData("203.0.113.9"))), "203.0.113.9", ""},
+		{"IN class", concat(dnsHeader(id, 0x8180, 1, 1), dnsQuestion(dnsClassIN),
+			dnsRecord(pointerToQuestion, dnsTypeTXT, dnsClassIN, txtData("2001:db8::9"))), "2001:db8::9", ""},
+		{"multi-string TXT", concat(dnsHeader(id, 0x8180, 1, 1), question,
+			dnsRecord(pointerToQuestion, dnsTypeTXT, dnsClassCH, txtData("2001:db8:", ":9"))), "2001:db8::9", ""},
+		{"quoted TXT", concat(dnsHeader(id, 0x8180, 1, 1), question,
+			dnsRecord(pointerToQuestion, dnsTypeTXT, dnsClassCH, txtData(`"203.0.113.9"`))), "203.0.113.9", ""},
+		{"skips other records", concat(dnsHeader(id, 0x8180, 1, 2), question,
+			dnsRecord(pointerToQuestion, 5, dnsClassCH, []byte("\x03foo\x00")),
+			answer), "203.0.113.9", ""},
+		{"skips empty TXT", concat(dnsHeader(id, 0x8180, 1, 2), question,
+			dnsRecord(pointerToQuestion, dnsTypeTXT, dnsClassCH, nil),
+			answer), "203.0.113.9", ""},
+		{"pointer loop", concat(dnsHeader(id, 0x8180, 1, 1), question,
+			dnsRecord([]byte{0xc0, byte(12 + len(question))}, dnsTypeTXT, dnsClassCH, txtData("203.0.113.9"))), "203.0.113.9", ""},
+		{"ID mismatch", concat(dnsHeader(id+1, 0x8180, 1, 1), question, answer), "", "ID mismatch"},
+		{"NXDOMAIN", concat(dnsHeader(id, 0x8183, 1, 0), question), "", "rcode 3"},
+		{"REFUSED", concat(dnsHeader(id, 0x8185, 1, 0), question), "", "rcode 5"},
+		{"no answer", concat(dnsHeader(id, 0x8180, 1, 0), question), "", "no TXT record"},
+		{"truncated header", ok[:11], "", "malformed"},
+		{"empty", nil, "", "malformed"},
+		{"truncated question name", ok[:16], "", "malformed"},
+		{"truncated question", ok[:12+len(question)-2], "", "malformed"},
+		{"truncated answer name", ok[:12+len(question)+1], "", "malformed"},
+		{"truncated answer header", ok[:12+len(question)+8], "", "malformed"},
+		{"truncated answer data", ok[:len(ok)-3], "", "malformed"},
+		{"TXT string past RDATA", concat(dnsHeader(id, 0x8180, 1, 1), question,
+			dnsRecord(pointerToQuestion, dnsTypeTXT, dnsClassCH, []byte("\x0a203"))), "", "malformed"},
+		{"answer count past end", concat(dnsHeader(id, 0x8180, 1, 3), question, dnsRecord(pointerToQuestion, 5, dnsClassCH, []byte{0})), "", "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTXTResponse(tt.msg, id)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipDNSName(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     []byte
+		offset  int
+		want    int
+		wantErr bool
+	}{
+		{"root", []byte{0}, 0, 1, false},
+		{"labels", []byte("\x03www\x07example\x03com\x00"), 0, 17, false},
+		{"pointer", []byte{0xc0, 0x00}, 0, 2, false},
+		{"pointer to itself is not followed", []byte{0, 0, 0xc0, 0x02}, 2, 4, false},
+		{"label then pointer", []byte("\x03www\xc0\x00"), 0, 6, false},
+		{"truncated pointer", []byte{0xc0}, 0, 0, true},
+		{"label past end", []byte("\x05ab"), 0, 0, true},
+		{"missing terminator", []byte("\x03www"), 0, 0, true},
+		{"offset past end", []byte{0}, 1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := skipDNSName(tt.msg, tt.offset)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("offset = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// startDNSServer runs a fake DNS server on localhost answering TXT queries
+// with the address for their class. With truncate set, UDP answers only
+// carry the TC bit and the full answer is served over TCP.
+func startDNSServer(t *testing.T, truncate bool) string {
+	t.Helper()
+	answer := func(query []byte) []byte {
+		class := binary.BigEndian.Uint16(query[len(query)-2:])
+		ip := "203.0.113.53"
+		if class == dnsClassCH {
+			ip = "198.51.100.53"
+		}
+		resp := concat(query[:12], query[12:])
+		binary.BigEndian.PutUint16(resp[2:], 0x8180)
+		binary.BigEndian.PutUint16(resp[6:], 1)
+		return concat(resp, dnsRecord(pointerToQuestion, dnsTypeTXT, class, txtData(ip)))
+	}
+
+	udp, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udp.Close() })
+	address := udp.LocalAddr().String()
+
+	tcp, err := net.Listen("tcp4", address)
+	if err != nil {
+		t.Skipf("TCP port %s is taken: %v", address, err)
+	}
+	t.Cleanup(func() { tcp.Close() })
+
+	go func() {
+		buf := make([]byte, dnsMaxPacket)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := answer(buf[:n])
+			if truncate {
+				resp = concat(buf[:n])
+				binary.BigEndian.PutUint16(resp[2:], 0x8380) // TC
+			}
+			udp.WriteTo(resp, addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := answer(query)
+				conn.Write(concat(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp))
+			}()
+		}
+	}()
+	return address
+}
+
+func TestQueryTXT(t *testing.T) {
+	tests := []struct {
+		name     string
+		class    uint16
+		truncate bool
+		want     string
+	}{
+		{"CH", dnsClassCH, false, "198.51.100.53"},
+		{"IN", dnsClassIN, false, "203.0.113.53"},
+		{"CH over TCP after truncation", dnsClassCH, true, "198.51.100.53"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startDNSServer(t, tt.truncate)
+			got, err := queryTXT(context.Background(), "4", server, "whoami.cloudflare", tt.class)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTXTCancelled(t *testing.T) {
+	// A server that never answers
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := queryTXT(ctx, "4", conn.LocalAddr().String(), "whoami.cloudflare", dnsClassCH); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
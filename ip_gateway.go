@@ -0,0 +1,374 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Router protocol settings
+const (
+	ssdpAddress    = "239.255.255.250:1900"
+	ssdpTimeout    = 3 * time.Second
+	natpmpPort     = "5351"
+	natpmpTimeout  = 3 * time.Second
+	natpmpRetries  = 3
+	upnpDeviceType = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+)
+
+// upnpServiceTypes are the WAN connection services that can report the
+// external address, in order of preference
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpProvider asks a UPnP Internet Gateway Device for its external IPv4
+type upnpProvider struct {
+	// location is the device description URL; discovered via SSDP when empty
+	location string
+	client   *http.Client
+
+	mu          sync.Mutex
+	controlURL  string
+	serviceType string
+}
+
+// Name returns the description URL or "upnp" when discovered
+func (p *upnpProvider) Name() string {
+	if p.location != "" {
+		return "upnp " + p.location
+	}
+	return "upnp"
+}
+
+// GetIP calls GetExternalIPAddress on the gateway's WAN connection service
+func (p *upnpProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	if ipv6 {
+		return "", fmt.Errorf("UPnP only provides IPv4 addresses")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The control URL is cached; discovery is only repeated after a failure
+	if p.controlURL == "" {
+		if err := p.discover(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	ip, err := p.getExternalIPAddress(ctx)
+	if err != nil {
+		p.controlURL = ""
+		return "", err
+	}
+	return checkGatewayAddress(ip)
+}
+
+// discover finds the gateway's description and WAN connection service
+func (p *upnpProvider) discover(ctx context.Context) error {
+	location := p.location
+	if location == "" {
+		var err error
+		location, err = discoverIGD(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	body, err := fetchURL(ctx, p.client, location)
+	if err != nil {
+		return fmt.Errorf("failed to fetch device description: %w", err)
+	}
+
+	var root upnpRoot
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return fmt.Errorf("failed to parse device description: %w", err)
+	}
+
+	service, ok := root.Device.findService(upnpServiceTypes)
+	if !ok {
+		return fmt.Errorf("no WAN connection service found at %s", location)
+	}
+
+	base := location
+	if root.URLBase != "" {
+		base = root.URLBase
+	}
+	controlURL, err := resolveURL(base, service.ControlURL)
+	if err != nil {
+		return fmt.Errorf("invalid control URL: %w", err)
+	}
+
+	p.controlURL = controlURL
+	p.serviceType = service.ServiceType
+	return nil
+}
+
+// getExternalIPAddress performs the GetExternalIPAddress SOAP action
+func (p *upnpProvider) getExternalIPAddress(ctx context.Context) (string, error) {
+	envelope := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + p.serviceType + `"/></s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.controlURL, strings.NewReader(envelope))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+p.serviceType+`#GetExternalIPAddress"`)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d from %s", resp.StatusCode, p.controlURL)
+	}
+
+	var envelopeResp struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(body, &envelopeResp); err != nil {
+		return "", fmt.Errorf("failed to parse SOAP response: %w", err)
+	}
+	if envelopeResp.IP == "" {
+		return "", fmt.Errorf("gateway returned no external address")
+	}
+
+	return strings.TrimSpace(envelopeResp.IP), nil
+}
+
+// upnpRoot is the root of a UPnP device description
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// upnpDevice is a (possibly nested) device in a description
+type upnpDevice struct {
+	DeviceType string        `xml:"deviceType"`
+	Services   []upnpService `xml:"serviceList>service"`
+	Devices    []upnpDevice  `xml:"deviceList>device"`
+}
+
+// upnpService is a service offered by a device
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findService searches the device tree for the first matching service type
+func (d *upnpDevice) findService(serviceTypes []string) (upnpService, bool) {
+	for _, serviceType := range serviceTypes {
+		if service, ok := d.findServiceType(serviceType); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// findServiceType searches the device tree for a single service type
+func (d *upnpDevice) findServiceType(serviceType string) (upnpService, bool) {
+	for _, service := range d.Services {
+		if service.ServiceType == serviceType {
+			return service, true
+		}
+	}
+	for i := range d.Devices {
+		if service, ok := d.Devices[i].findServiceType(serviceType); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// resolveURL resolves ref relative to base
+func resolveURL(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// discoverIGD finds an Internet Gateway Device with an SSDP M-SEARCH and
+// returns the location of its description
+func discoverIGD(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + upnpDeviceType + "\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return "", fmt.Errorf("failed to send SSDP search: %w", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(ssdpTimeout))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			return "", fmt.Errorf("no UPnP gateway found")
+		}
+
+		// Responses are HTTP-like; only the LOCATION header is needed
+		for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+			name, value, ok := strings.Cut(line, ":")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "location") {
+				return strings.TrimSpace(value), nil
+			}
+		}
+	}
+}
+
+// natpmpProvider asks a NAT-PMP gateway for its external IPv4 address.
+// PCP gateways answer too when they implement the NAT-PMP compatibility
+// described in RFC 6887.
+type natpmpProvider struct {
+	// gateway is host[:port]; the default gateway is used when empty
+	gateway string
+}
+
+// Name returns the gateway address
+func (p *natpmpProvider) Name() string {
+	if p.gateway != "" {
+		return "natpmp " + p.gateway
+	}
+	return "natpmp"
+}
+
+// GetIP sends a public address request and returns the reported address
+func (p *natpmpProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	if ipv6 {
+		return "", fmt.Errorf("NAT-PMP only provides IPv4 addresses")
+	}
+
+	gateway := p.gateway
+	if gateway == "" {
+		gw, err := defaultGateway()
+		if err != nil {
+			return "", err
+		}
+		gateway = gw.String()
+	}
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "udp4", withDefaultPort(gateway, natpmpPort))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	// Version 0, opcode 0 (public address request); retried as UDP may drop
+	request := []byte{0, 0}
+	buf := make([]byte, 16)
+	for attempt := 0; attempt < natpmpRetries; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return "", fmt.Errorf("failed to send NAT-PMP request: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(natpmpTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			continue
+		}
+
+		return parseNATPMPResponse(buf[:n])
+	}
+
+	return "", fmt.Errorf("no NAT-PMP response from %s", gateway)
+}
+
+// parseNATPMPResponse decodes a public address response:
+// version, opcode (128), result code, epoch and the IPv4 address
+func parseNATPMPResponse(resp []byte) (string, error) {
+	if len(resp) < 12 || resp[1] != 128 {
+		return "", fmt.Errorf("malformed NAT-PMP response")
+	}
+	if resp[0] != 0 {
+		return "", fmt.Errorf("unsupported NAT-PMP version %d", resp[0])
+	}
+	if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+		return "", fmt.Errorf("NAT-PMP request failed with result code %d", result)
+	}
+
+	ip := net.IP(bytes.Clone(resp[8:12]))
+	return checkGatewayAddress(ip.String())
+}
+
+// checkGatewayAddress rejects what a gateway reports while it has no usable
+// WAN address, such as 0.0.0.0, or a private or CGNAT address when it sits
+// behind another NAT, so the next provider is tried
+func checkGatewayAddress(address string) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		return "", fmt.Errorf("gateway returned invalid IPv4 address %q", address)
+	}
+	if !isPublicIP(ip) {
+		return "", fmt.Errorf("gateway returned non-public address %s", ip)
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,241 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// natpmpReply builds a public address response with the given result code
+func natpmpReply(result uint16, ip string) []byte {
+	resp := []byte{0, 128, 0, 0, 0, 0, 0, 1}
+	binary.BigEndian.PutUint16(resp[2:], result)
+	return append(resp, net.ParseIP(ip).To4()...)
+}
+
+func TestParseNATPMPResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    []byte
+		want    string
+		wantErr bool
+	}{
+		{"public", natpmpReply(0, "203.0.113.5"), "203.0.113.5", false},
+		{"unspecified", natpmpReply(0, "0.0.0.0"), "", true},
+		{"private", natpmpReply(0, "192.168.1.2"), "", true},
+		{"cgnat", natpmpReply(0, "100.64.0.1"), "", true},
+		{"result code", natpmpReply(3, "203.0.113.5"), "", true},
+		{"short", []byte{0, 128, 0, 0}, "", true},
+		{"wrong opcode", append([]byte{0, 129}, natpmpReply(0, "203.0.113.5")[2:]...), "", true},
+		{"wrong version", append([]byte{1}, natpmpReply(0, "203.0.113.5")[1:]...), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNATPMPResponse(tt.resp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// startNATPMPGateway runs a fake NAT-PMP gateway on localhost answering
+// every request with reply
+func startNATPMPGateway(t *testing.T, reply []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n == 2 && buf[0] == 0 && buf[1] == 0 {
+				conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNATPMPProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   []byte
+		want    string
+		wantErr bool
+	}{
+		{"public", natpmpReply(0, "198.51.100.20"), "198.51.100.20", false},
+		{"unspecified", natpmpReply(0, "0.0.0.0"), "", true},
+		{"private", natpmpReply(0, "10.0.0.1"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &natpmpProvider{gateway: startNATPMPGateway(t, tt.reply)}
+			got, err := provider.GetIP(context.Background(), false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// upnpDescription is a device description with the WAN connection service
+// nested the way real gateways report it
+const upnpDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+// startUPnPGateway runs a fake UPnP IGD reporting address, or failing the
+// SOAP action with status when it is not 200
+func startUPnPGateway(t *testing.T, address string, status int) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, upnpDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		action := "urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"
+		if r.Method != http.MethodPost || r.Header.Get("SOAPAction") != `"`+action+`"` {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+      <NewExternalIPAddress>%s</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>`, address)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUPnPProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		status  int
+		want    string
+		wantErr string
+	}{
+		{"public", "203.0.113.77", http.StatusOK, "203.0.113.77", ""},
+		{"unspecified", "0.0.0.0", http.StatusOK, "", "non-public"},
+		{"private", "192.168.0.10", http.StatusOK, "", "non-public"},
+		{"cgnat", "100.100.1.1", http.StatusOK, "", "non-public"},
+		{"empty", "", http.StatusOK, "", "no external address"},
+		{"invalid", "not-an-ip", http.StatusOK, "", "invalid"},
+		{"soap fault", "", http.StatusInternalServerError, "", "HTTP 500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startUPnPGateway(t, tt.address, tt.status)
+			provider := &upnpProvider{location: server.URL + "/rootDesc.xml", client: server.Client()}
+
+			got, err := provider.GetIP(context.Background(), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUPnPProviderRejectsIPv6(t *testing.T) {
+	provider := &upnpProvider{location: "http://127.0.0.1:1/rootDesc.xml", client: http.DefaultClient}
+	if _, err := provider.GetIP(context.Background(), true); err == nil {
+		t.Fatal("expected an error for IPv6")
+	}
+}
+
+func TestGatewayAddressFallsBackToNextProvider(t *testing.T) {
+	detector := &IPDetector{
+		ipv4Providers: []IPProvider{
+			&natpmpProvider{gateway: startNATPMPGateway(t, natpmpReply(0, "0.0.0.0"))},
+			&commandProvider{command: []string{"echo", "198.51.100.9"}},
+		},
+		mode: DetectionModeFirst,
+	}
+
+	got, err := detector.GetIPv4(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "198.51.100.9" {
+		t.Errorf("got %q, want the next provider's address", got)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Linux address flags (IFA_F_*), reported by addressFlags
+const (
+	addrFlagTemporary  = 0x01
+	addrFlagDADFailed  = 0x08
+	addrFlagDeprecated = 0x20
+	addrFlagTentative  = 0x40
+)
+
+// cgnatRange is the shared address space used by carrier-grade NAT (RFC 6598)
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// interfaceProvider reads addresses bound to a local network interface
+type interfaceProvider struct {
+	iface string
+}
+
+// Name returns the interface name
+func (p *interfaceProvider) Name() string {
+	return "interface " + p.iface
+}
+
+// GetIP returns the first public address of the interface
+func (p *interfaceProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	return getIPFromInterface(p.iface, ipv6)
+}
+
+// getIPFromInterface returns the first public address of the requested family
+// bound to the named interface
+func getIPFromInterface(name string, ipv6 bool) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+	}
+
+	// Flags are only available on some platforms; without them every
+	// public address is considered usable
+	flags, _ := addressFlags(iface.Index)
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		ip := ipNet.IP
+		if (ip.To4() == nil) != ipv6 || !isPublicIP(ip) {
+			continue
+		}
+
+		if flags[ip.String()]&(addrFlagTemporary|addrFlagDADFailed|addrFlagDeprecated|addrFlagTentative) != 0 {
+			continue
+		}
+
+		return ip.String(), nil
+	}
+
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return "", fmt.Errorf("no public %s address found on interface %s", family, name)
+}
+
+// isPublicIP checks if the address is globally routable, excluding loopback,
+// link-local, private, unique local (ULA) and CGNAT addresses
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!cgnatRange.Contains(ip)
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ifaFlags is the IFA_FLAGS netlink attribute carrying the full 32-bit flags
+const ifaFlags = 8
+
+// addressFlags returns the IFA_F_* flags of every address on an interface,
+// keyed by the address string
+func addressFlags(index int) (map[string]uint32, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query addresses: %w", err)
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse netlink messages: %w", err)
+	}
+
+	flags := make(map[string]uint32)
+	for _, m := range msgs {
+		if m.Header.Type == syscall.NLMSG_DONE {
+			break
+		}
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		// struct ifaddrmsg: family, prefixlen, flags, scope (u8), index (u32)
+		if int(binary.NativeEndian.Uint32(m.Data[4:8])) != index {
+			continue
+		}
+		addrFlags := uint32(m.Data[2])
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			continue
+		}
+
+		var ip net.IP
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				if ip == nil {
+					ip = net.IP(attr.Value)
+				}
+			case syscall.IFA_LOCAL:
+				ip = net.IP(attr.Value)
+			case ifaFlags:
+				if len(attr.Value) >= 4 {
+					addrFlags = binary.NativeEndian.Uint32(attr.Value)
+				}
+			}
+		}
+
+		if ip != nil {
+			flags[ip.String()] = addrFlags
+		}
+	}
+
+	return flags, nil
+}
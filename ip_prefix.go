@@ -0,0 +1,73 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "net"
+
+// hostIPv6 builds the AAAA content for the domain's host from a detected
+// IPv6 address, keeping its first ipv6_prefix_length bits and taking the
+// rest from ipv6_suffix or the EUI-64 of ipv6_mac. Without either, or if
+// the detected address isn't IPv6, it is returned unchanged.
+func (d *DomainConfig) hostIPv6(detected string) string {
+	id := d.interfaceID()
+	ip := net.ParseIP(detected)
+	if id == nil || ip == nil || ip.To4() != nil {
+		return detected
+	}
+	return combinePrefix(ip, id, d.IPv6PrefixLength).String()
+}
+
+// interfaceID returns the configured interface identifier as a 16-byte
+// address, or nil if none is set
+func (d *DomainConfig) interfaceID() net.IP {
+	if d.IPv6Suffix != "" {
+		return net.ParseIP(d.IPv6Suffix)
+	}
+	if d.IPv6MAC != "" {
+		if mac, err := net.ParseMAC(d.IPv6MAC); err == nil && len(mac) == 6 {
+			return eui64(mac)
+		}
+	}
+	return nil
+}
+
+// combinePrefix keeps the first prefixLength bits of prefix and takes the
+// remaining bits from id
+func combinePrefix(prefix, id net.IP, prefixLength int) net.IP {
+	prefix, id = prefix.To16(), id.To16()
+	mask := net.CIDRMask(prefixLength, 128)
+
+	ip := make(net.IP, net.IPv6len)
+	for i := range ip {
+		ip[i] = prefix[i]&mask[i] | id[i]&^mask[i]
+	}
+	return ip
+}
+
+// eui64 returns the modified EUI-64 interface identifier of a MAC address
+// (RFC 4291 appendix A): ff:fe inserted in the middle and the
+// universal/local bit flipped
+func eui64(mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[8:11], mac[0:3])
+	ip[8] ^= 0x02
+	ip[11], ip[12] = 0xff, 0xfe
+	copy(ip[13:16], mac[3:6])
+	return ip
+}
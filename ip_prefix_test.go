@@ -0,0 +1,146 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestEUI64(t *testing.T) {
+	tests := []struct {
+		mac  string
+		want string
+	}{
+		// U/L bit set by the flip, ff:fe in the middle
+		{"52:54:00:12:34:56", "::5054:ff:fe12:3456"},
+		{"00:11:22:33:44:55", "::211:22ff:fe33:4455"},
+		// U/L bit cleared by the flip
+		{"02:00:00:00:00:01", "::ff:fe00:1"},
+		{"ff:ff:ff:ff:ff:ff", "::fdff:ffff:feff:ffff"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mac, func(t *testing.T) {
+			mac, err := net.ParseMAC(tt.mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := eui64(mac).String(); got != tt.want {
+				t.Errorf("eui64(%s) = %s, want %s", tt.mac, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombinePrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		prefix       string
+		id           string
+		prefixLength int
+		want         string
+	}{
+		{"/64", "2001:db8:1:2::9", "::1234:5678", 64, "2001:db8:1:2::1234:5678"},
+		{"/56 picks subnet", "2001:db8:1:200::1", "0:0:0:5::10", 56, "2001:db8:1:205::10"},
+		{"/56 drops detected subnet", "2001:db8:1:2ab::1", "0:0:0:5::10", 56, "2001:db8:1:205::10"},
+		{"/48", "2001:db8:1:ffff::1", "0:0:0:42::1", 48, "2001:db8:1:42::1"},
+		{"/60 odd boundary", "2001:db8:1:2ff::1", "0:0:0:3::1", 60, "2001:db8:1:2f3::1"},
+		{"/127", "2001:db8::8", "::1", 127, "2001:db8::9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := combinePrefix(net.ParseIP(tt.prefix), net.ParseIP(tt.id), tt.prefixLength)
+			if got.String() != tt.want {
+				t.Errorf("combinePrefix = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostIPv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		domain   DomainConfig
+		detected string
+		want     string
+	}{
+		{"no identifier", DomainConfig{}, "2001:db8:1:2::9", "2001:db8:1:2::9"},
+		{"/56 suffix", DomainConfig{IPv6Suffix: "0:0:0:5::10", IPv6PrefixLength: 56}, "2001:db8:1:200::1", "2001:db8:1:205::10"},
+		{"/64 suffix", DomainConfig{IPv6Suffix: "::1234:5678", IPv6PrefixLength: 64}, "2001:db8:1:2::9", "2001:db8:1:2::1234:5678"},
+		{"/64 mac", DomainConfig{IPv6MAC: "52:54:00:12:34:56", IPv6PrefixLength: 64}, "2001:db8:1:2::9", "2001:db8:1:2:5054:ff:fe12:3456"},
+		{"IPv4 unchanged", DomainConfig{IPv6Suffix: "::1", IPv6PrefixLength: 64}, "203.0.113.9", "203.0.113.9"},
+		{"invalid unchanged", DomainConfig{IPv6Suffix: "::1", IPv6PrefixLength: 64}, "not-an-ip", "not-an-ip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.domain.hostIPv6(tt.detected); got != tt.want {
+				t.Errorf("hostIPv6(%s) = %s, want %s", tt.detected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateIPv6Host(t *testing.T) {
+	tests := []struct {
+		name       string
+		domain     DomainConfig
+		wantErr    string
+		wantLength int
+	}{
+		{"unset", DomainConfig{RecordTypes: "A"}, "", 0},
+		{"suffix defaults to /64", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "::1234:5678"}, "", 64},
+		{"/56 suffix", DomainConfig{RecordTypes: "both", IPv6Suffix: "0:0:0:5::10", IPv6PrefixLength: 56}, "", 56},
+		{"/64 mac", DomainConfig{RecordTypes: "AAAA", IPv6MAC: "52:54:00:12:34:56"}, "", 64},
+		{"length without identifier", DomainConfig{RecordTypes: "AAAA", IPv6PrefixLength: 56}, "requires ipv6_suffix or ipv6_mac", 0},
+		{"both set", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "::1", IPv6MAC: "52:54:00:12:34:56"}, "cannot both be set", 0},
+		{"A records only", DomainConfig{RecordTypes: "A", IPv6Suffix: "::1"}, "require AAAA records", 0},
+		{"length too large", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "::1", IPv6PrefixLength: 128}, "between 1 and 127", 0},
+		{"length negative", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "::1", IPv6PrefixLength: -1}, "between 1 and 127", 0},
+		{"invalid mac", DomainConfig{RecordTypes: "AAAA", IPv6MAC: "52:54:00:12:34"}, "48-bit MAC", 0},
+		{"64-bit mac", DomainConfig{RecordTypes: "AAAA", IPv6MAC: "02:00:5e:10:00:00:00:01"}, "48-bit MAC", 0},
+		{"mac with /56", DomainConfig{RecordTypes: "AAAA", IPv6MAC: "52:54:00:12:34:56", IPv6PrefixLength: 56}, "must be 64 with ipv6_mac", 0},
+		{"IPv4 suffix", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "0.0.0.1"}, "must be an IPv6 address", 0},
+		{"invalid suffix", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "1234:5678"}, "must be an IPv6 address", 0},
+		{"suffix overlaps /64", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "0:0:0:5::10"}, "inside the /64 prefix", 0},
+		{"suffix overlaps /56", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "0:0:0:105::10", IPv6PrefixLength: 56}, "inside the /56 prefix", 0},
+		{"full address suffix", DomainConfig{RecordTypes: "AAAA", IPv6Suffix: "2001:db8::1"}, "inside the /64 prefix", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.domain.validateIPv6Host()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.domain.IPv6PrefixLength != tt.wantLength {
+				t.Errorf("ipv6_prefix_length = %d, want %d", tt.domain.IPv6PrefixLength, tt.wantLength)
+			}
+		})
+	}
+}
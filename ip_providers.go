@@ -0,0 +1,187 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IP provider types
+const (
+	ProviderHTTP      = "http"
+	ProviderHTTPJSON  = "http_json"
+	ProviderInterface = "interface"
+	ProviderCommand   = "command"
+	ProviderDNS       = "dns"
+	ProviderUPnP      = "upnp"
+	ProviderNATPMP    = "natpmp"
+)
+
+// commandTimeout bounds how long a command provider may run
+const commandTimeout = 10 * time.Second
+
+// IPProvider is a source of public IP addresses
+type IPProvider interface {
+	// Name identifies the provider in logs
+	Name() string
+
+	// GetIP returns the current IPv4 or IPv6 address
+	GetIP(ctx context.Context, ipv6 bool) (string, error)
+}
+
+// newIPProviders creates providers from their configuration, in order
+func newIPProviders(configs []IPProviderConfig, client *http.Client) []IPProvider {
+	providers := make([]IPProvider, 0, len(configs))
+	for _, config := range configs {
+		providers = append(providers, newIPProvider(config, client))
+	}
+	return providers
+}
+
+// newIPProvider creates a provider from an already validated configuration
+func newIPProvider(config IPProviderConfig, client *http.Client) IPProvider {
+	switch config.Type {
+	case ProviderHTTPJSON:
+		return &httpJSONProvider{url: config.URL, field: config.Field, client: client}
+	case ProviderInterface:
+		return &interfaceProvider{iface: config.Interface}
+	case ProviderCommand:
+		return &commandProvider{command: config.Command}
+	case ProviderDNS:
+		return &dnsProvider{
+			resolver:   config.Resolver,
+			query:      config.Query,
+			class:      config.Class,
+			recordType: config.RecordType,
+		}
+	case ProviderUPnP:
+		return &upnpProvider{location: config.URL, client: client}
+	case ProviderNATPMP:
+		return &natpmpProvider{gateway: config.Gateway}
+	default:
+		return &httpProvider{url: config.URL, client: client}
+	}
+}
+
+// httpProvider reads a plain text address from an HTTP service
+type httpProvider struct {
+	url    string
+	client *http.Client
+}
+
+// Name returns the service URL
+func (p *httpProvider) Name() string {
+	return p.url
+}
+
+// GetIP fetches the address from the service
+func (p *httpProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	return getIPFromService(ctx, p.client, p.url)
+}
+
+// httpJSONProvider reads an address from a field of a JSON HTTP response
+type httpJSONProvider struct {
+	url    string
+	field  string
+	client *http.Client
+}
+
+// Name returns the service URL and field path
+func (p *httpJSONProvider) Name() string {
+	return p.url + "#" + p.field
+}
+
+// GetIP fetches the JSON document and extracts the address field
+func (p *httpJSONProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	body, err := fetchURL(ctx, p.client, p.url)
+	if err != nil {
+		return "", err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse JSON from %s: %w", p.url, err)
+	}
+
+	value, err := lookupJSONField(doc, p.field)
+	if err != nil {
+		return "", err
+	}
+
+	ip, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %s is not a string", p.field)
+	}
+	return strings.TrimSpace(ip), nil
+}
+
+// lookupJSONField follows a dot-separated path such as "data.ip" or
+// "addresses.0" through decoded JSON objects and arrays
+func lookupJSONField(doc interface{}, path string) (interface{}, error) {
+	current := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("field %s not found", path)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("field %s not found", path)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("field %s not found", path)
+		}
+	}
+	return current, nil
+}
+
+// commandProvider runs a local command that prints the address
+type commandProvider struct {
+	command []string
+}
+
+// Name returns the command line
+func (p *commandProvider) Name() string {
+	return strings.Join(p.command, " ")
+}
+
+// GetIP runs the command and returns its trimmed output
+func (p *commandProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, p.command[0], p.command[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
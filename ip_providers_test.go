@@ -0,0 +1,182 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startIPService runs a fake IP service answering every request with
+// status and body
+func startIPService(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{"plain", http.StatusOK, "203.0.113.10", "203.0.113.10", ""},
+		{"trailing newline", http.StatusOK, " 2001:db8::10\n", "2001:db8::10", ""},
+		{"not found", http.StatusNotFound, "203.0.113.10", "", "HTTP 404"},
+		{"server error", http.StatusInternalServerError, "", "", "HTTP 500"},
+		{"unavailable", http.StatusServiceUnavailable, "try again", "", "HTTP 503"},
+		{"no content", http.StatusNoContent, "", "", "HTTP 204"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startIPService(t, tt.status, tt.body)
+			provider := &httpProvider{url: server.URL, client: server.Client()}
+
+			got, err := provider.GetIP(context.Background(), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPJSONProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		field   string
+		want    string
+		wantErr string
+	}{
+		{"top level", http.StatusOK, `{"ip": "203.0.113.20"}`, "ip", "203.0.113.20", ""},
+		{"nested", http.StatusOK, `{"data": {"client": {"ip": "203.0.113.21"}}}`, "data.client.ip", "203.0.113.21", ""},
+		{"array index", http.StatusOK, `{"addresses": ["203.0.113.22", "2001:db8::22"]}`, "addresses.1", "2001:db8::22", ""},
+		{"object in array", http.StatusOK, `[{"ip": "203.0.113.23"}]`, "0.ip", "203.0.113.23", ""},
+		{"whitespace", http.StatusOK, `{"ip": " 203.0.113.24\n"}`, "ip", "203.0.113.24", ""},
+		{"missing field", http.StatusOK, `{"address": "203.0.113.25"}`, "ip", "", "field ip not found"},
+		{"missing nested field", http.StatusOK, `{"data": {}}`, "data.ip", "", "field data.ip not found"},
+		{"path through string", http.StatusOK, `{"data": "203.0.113.26"}`, "data.ip", "", "field data.ip not found"},
+		{"index out of range", http.StatusOK, `{"addresses": ["203.0.113.27"]}`, "addresses.1", "", "field addresses.1 not found"},
+		{"negative index", http.StatusOK, `{"addresses": ["203.0.113.28"]}`, "addresses.-1", "", "field addresses.-1 not found"},
+		{"non-numeric index", http.StatusOK, `{"addresses": ["203.0.113.29"]}`, "addresses.first", "", "field addresses.first not found"},
+		{"number", http.StatusOK, `{"ip": 42}`, "ip", "", "field ip is not a string"},
+		{"null", http.StatusOK, `{"ip": null}`, "ip", "", "field ip is not a string"},
+		{"object", http.StatusOK, `{"ip": {"v4": "203.0.113.30"}}`, "ip", "", "field ip is not a string"},
+		{"invalid JSON", http.StatusOK, `{"ip": "203.0.113.31"`, "ip", "", "failed to parse JSON"},
+		{"HTML body", http.StatusOK, `<html>rate limited</html>`, "ip", "", "failed to parse JSON"},
+		{"empty body", http.StatusOK, ``, "ip", "", "failed to parse JSON"},
+		{"not found", http.StatusNotFound, `{"ip": "203.0.113.32"}`, "ip", "", "HTTP 404"},
+		{"server error", http.StatusInternalServerError, `{"error": "boom"}`, "ip", "", "HTTP 500"},
+		{"rate limited", http.StatusTooManyRequests, `{"ip": "203.0.113.33"}`, "ip", "", "HTTP 429"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startIPService(t, tt.status, tt.body)
+			provider := &httpJSONProvider{url: server.URL, field: tt.field, client: server.Client()}
+
+			got, err := provider.GetIP(context.Background(), false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProviderInvalidBodyFallsBackToNextProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"HTML", "<html>blocked</html>"},
+		{"empty", ""},
+		{"wrong family", "2001:db8::40"},
+		{"truncated", "203.0.113"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid := startIPService(t, http.StatusOK, tt.body)
+			valid := startIPService(t, http.StatusOK, "198.51.100.40\n")
+			detector := &IPDetector{
+				ipv4Providers: []IPProvider{
+					&httpProvider{url: invalid.URL, client: invalid.Client()},
+					&httpProvider{url: valid.URL, client: valid.Client()},
+				},
+				mode: DetectionModeFirst,
+			}
+
+			got, err := detector.GetIPv4(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "198.51.100.40" {
+				t.Errorf("got %q, want the next provider's address", got)
+			}
+		})
+	}
+}
+
+func TestHTTPProviderFailuresExhaustProviders(t *testing.T) {
+	unavailable := startIPService(t, http.StatusServiceUnavailable, "")
+	invalid := startIPService(t, http.StatusOK, "not an address")
+	detector := &IPDetector{
+		ipv4Providers: []IPProvider{
+			&httpProvider{url: unavailable.URL, client: unavailable.Client()},
+			&httpJSONProvider{url: invalid.URL, field: "ip", client: invalid.Client()},
+		},
+		mode: DetectionModeFirst,
+	}
+
+	_, err := detector.GetIPv4(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to parse JSON") {
+		t.Fatalf("error = %v, want the last provider's error", err)
+	}
+}
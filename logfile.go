@@ -0,0 +1,251 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permissions for log files and directories created by the updater
+const (
+	logFileMode = 0640
+	logDirMode  = 0750
+)
+
+// backupTimeFormat is appended to the log file name when it is rotated
+const backupTimeFormat = "20060102-150405.000"
+
+// logRotation configures when a log file is rotated and how many rotated
+// files are kept
+type logRotation struct {
+	MaxSize    int64         // bytes, 0 = no size limit
+	MaxAge     time.Duration // 0 = no age limit
+	MaxBackups int           // rotated files to keep
+	Compress   bool          // gzip rotated files
+}
+
+// logFile is an append-only log file that rotates itself by size and age
+// and can be reopened after an external tool such as logrotate moved it
+type logFile struct {
+	mu       sync.Mutex
+	path     string
+	rotation logRotation
+	file     *os.File
+	size     int64
+	started  time.Time
+}
+
+// openLogFile opens (creating if needed) the log file and its directory
+func openLogFile(path string, rotation logRotation) (*logFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), logDirMode); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	l := &logFile{path: path, rotation: rotation}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write appends p to the log file, rotating it first if it is due
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if l.rotateDue(len(p)) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+			if l.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the log file at its configured path
+func (l *logFile) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	return l.open()
+}
+
+// open opens the log file for appending
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, logFileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.started = time.Now()
+
+	// A non-empty file was started when the previous one was rotated
+	if l.size > 0 {
+		if backups := l.backups(); len(backups) > 0 {
+			l.started = backups[0].rotated
+		}
+	}
+	return nil
+}
+
+// rotateDue returns true if writing n more bytes should rotate the file first
+func (l *logFile) rotateDue(n int) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.rotation.MaxSize > 0 && l.size+int64(n) > l.rotation.MaxSize {
+		return true
+	}
+	return l.rotation.MaxAge > 0 && time.Since(l.started) >= l.rotation.MaxAge
+}
+
+// rotate moves the current file aside, compresses it if configured, removes
+// backups beyond the retention count and opens a fresh file
+func (l *logFile) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	backup := l.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil {
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rename log file: %w", err)
+	}
+
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	if l.rotation.Compress {
+		if err := compressFile(backup); err != nil {
+			return err
+		}
+	}
+	return l.removeOldBackups()
+}
+
+// logBackup is a rotated log file
+type logBackup struct {
+	path    string
+	rotated time.Time
+}
+
+// backups returns the rotated log files, newest first
+func (l *logFile) backups() []logBackup {
+	matches, _ := filepath.Glob(l.path + ".*")
+
+	var backups []logBackup
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, l.path+"."), ".gz")
+		rotated, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{path: match, rotated: rotated})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotated.After(backups[j].rotated)
+	})
+	return backups
+}
+
+// removeOldBackups deletes the oldest rotated files beyond MaxBackups
+func (l *logFile) removeOldBackups() error {
+	backups := l.backups()
+	if len(backups) <= l.rotation.MaxBackups {
+		return nil
+	}
+
+	var errs []string
+	for _, backup := range backups[l.rotation.MaxBackups:] {
+		if err := os.Remove(backup.path); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove old log files: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// compressFile gzips path to path.gz and removes the original
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, logFileMode)
+	if err != nil {
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
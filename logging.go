@@ -0,0 +1,141 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is the minimum level logged. It is a variable so that a
+// configuration reload can change it without replacing the handler.
+var logLevel = new(slog.LevelVar)
+
+// logOutput is the log file being written, nil when logging to stdout
+var logOutput *logFile
+
+// setupLogging installs a slog handler writing text or JSON to stdout or the
+// given log file. Messages from the standard log package go through it too.
+func setupLogging(path, format string, rotation logRotation) error {
+	var output io.Writer = os.Stdout
+	if path != "" {
+		file, err := openLogFile(path, rotation)
+		if err != nil {
+			return err
+		}
+		logOutput = file
+		output = file
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	switch format {
+	case LogFormatJSON:
+		options.ReplaceAttr = durationSeconds
+		handler = slog.NewJSONHandler(output, options)
+	default:
+		handler = slog.NewTextHandler(output, options)
+	}
+	slog.SetDefault(slog.New(handler))
+
+	if path != "" {
+		slog.Info("Logging to file", "path", path)
+	}
+	return nil
+}
+
+// handleLogReopen reopens the log file whenever SIGUSR1 is received, so
+// external tools like logrotate can move it away, until ctx is cancelled
+func handleLogReopen(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	notifyLogReopen(signals)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if logOutput == nil {
+				slog.Debug("Log reopen requested but not logging to a file")
+				continue
+			}
+			if err := logOutput.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+				continue
+			}
+			slog.Info("Reopened log file", "path", logOutput.path)
+		}
+	}
+}
+
+// durationSeconds writes durations as fractional seconds in JSON logs
+// instead of integer nanoseconds
+func durationSeconds(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindDuration {
+		attr.Value = slog.Float64Value(attr.Value.Duration().Seconds())
+	}
+	return attr
+}
+
+// setLogLevel applies the configured log level; verbose forces debug
+func setLogLevel(level string, verbose bool) error {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		parsed = slog.LevelDebug
+	}
+	logLevel.Set(parsed)
+	return nil
+}
+
+// parseLogLevel converts a log_level setting into a slog level
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("log_level must be 'debug', 'info', 'warn' or 'error'")
+	}
+}
+
+// debugEnabled returns true if debug messages are being logged
+func debugEnabled() bool {
+	return logLevel.Level() <= slog.LevelDebug
+}
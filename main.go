@@ -19,9 +19,9 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -35,63 +35,116 @@ const (
 )
 
 func main() {
-	// Parse command line flags
-	configFile := flag.String("config", "cf-ddns.conf", "Path to configuration file")
-	verbose := flag.Bool("verbose", false, "Enable verbose logging")
-	logFile := flag.String("log", "", "Log file path (optional, logs to stdout if not specified)")
-	runOnce := flag.Bool("once", false, "Run once and exit (ignore interval setting)")
-	versionFlag := flag.Bool("version", false, "Show version information and exit")
-	flag.Parse()
+	os.Exit(runCLI(os.Args[1:]))
+}
 
-	// Handle version flag
-	if *versionFlag {
-		fmt.Printf("%s v%s\n", AppName, Version)
-		os.Exit(0)
+// runContinuous runs updates every interval seconds until ctx is cancelled.
+// A signal on reload (or a change to the config file when watch_config is
+// set) reloads the configuration between updates. forceVerbose keeps debug
+// logging on across reloads when it was requested on the command line.
+func runContinuous(ctx context.Context, updater *DDNSUpdater, reload <-chan os.Signal, configFile string, forceVerbose bool) {
+	interval := updater.config.Interval
+	slog.Info("Starting continuous mode", "interval", time.Duration(interval)*time.Second)
+
+	if updater.config.HTTPListen != "" {
+		if err := startHTTPServer(ctx, updater.config.HTTPListen, updater.health); err != nil {
+			slog.Warn("HTTP listener not started", "error", err)
+		}
 	}
 
-	fmt.Printf("%s v%s\n", AppName, Version)
-
-	// Load configuration first to get log file setting
-	config, err := loadConfig(*configFile)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	var configChanged <-chan struct{}
+	if updater.config.WatchConfig {
+		changes, err := watchConfigFile(ctx, updater.config.path)
+		if err != nil {
+			slog.Warn("Failed to watch configuration file", "error", err)
+		} else {
+			slog.Info("Watching configuration file for changes", "path", updater.config.path)
+			configChanged = changes
+		}
 	}
 
-	// Determine log file: command line flag takes precedence over config file
-	logFileToUse := *logFile
-	if logFileToUse == "" && config.LogFile != "" {
-		logFileToUse = config.LogFile
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Shutdown signal received, exiting")
+			return
+		case <-reload:
+			slog.Info("SIGHUP received, reloading configuration")
+			if reloadConfig(updater, configFile, forceVerbose) {
+				interval = updater.config.Interval
+				resetTimer(timer, 0)
+			}
+			continue
+		case <-configChanged:
+			slog.Info("Configuration file changed, reloading configuration")
+			if reloadConfig(updater, configFile, forceVerbose) {
+				interval = updater.config.Interval
+				resetTimer(timer, 0)
+			}
+			continue
+		case <-timer.C:
+		}
+
+		if err := updater.Update(ctx); err != nil {
+			if ctx.Err() != nil {
+				slog.Info("Shutdown signal received, update aborted")
+				return
+			}
+			slog.Error("Failed to update DNS records", "error", err)
+		} else {
+			slog.Info("DNS records updated successfully")
+		}
+
+		slog.Debug("Waiting before next update", "interval", time.Duration(interval)*time.Second)
+		timer.Reset(time.Duration(interval) * time.Second)
 	}
+}
 
-	// Setup logging
-	if err := setupLogging(*verbose, logFileToUse); err != nil {
-		log.Fatalf("Failed to setup logging: %v", err)
+// reloadConfig loads and validates the configuration file again and swaps
+// it into the updater, keeping the old configuration if anything is wrong.
+// It returns true if the new configuration was applied.
+func reloadConfig(updater *DDNSUpdater, configFile string, forceVerbose bool) bool {
+	config, err := loadConfig(configFile)
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping previous configuration", "error", err)
+		return false
 	}
 
-	// Initialize updater
-	updater := NewDDNSUpdater(config, *verbose)
+	if config.Interval <= 0 {
+		slog.Warn("Configuration reload: interval must be positive in continuous mode, keeping previous interval",
+			"interval", time.Duration(updater.config.Interval)*time.Second)
+		config.Interval = updater.config.Interval
+	}
+	if config.LogFile != updater.config.LogFile || config.logRotation() != updater.config.logRotation() {
+		slog.Warn("Configuration reload: log_file and log rotation changes take effect after a restart")
+	}
+	if config.LogFormat != updater.config.LogFormat {
+		slog.Warn("Configuration reload: log_format changes take effect after a restart")
+	}
+	if config.HTTPListen != updater.config.HTTPListen {
+		slog.Warn("Configuration reload: http_listen changes take effect after a restart")
+	}
 
-	// Run update loop
-	if *runOnce || config.Interval <= 0 {
-		// Run once
-		if err := updater.Update(); err != nil {
-			log.Fatalf("Failed to update DNS records: %v", err)
-		}
-		log.Println("DNS records updated successfully")
-	} else {
-		// Run continuously with interval
-		log.Printf("Starting continuous mode with %d second interval", config.Interval)
-		for {
-			if err := updater.Update(); err != nil {
-				log.Printf("Failed to update DNS records: %v", err)
-			} else {
-				log.Println("DNS records updated successfully")
-			}
+	if err := setLogLevel(config.LogLevel, forceVerbose || config.Verbose); err != nil {
+		slog.Warn("Configuration reload: invalid log level", "error", err)
+	}
+	updater.Reload(config)
+	slog.Info("Configuration reloaded", "domains", len(config.Domains), "interval", time.Duration(config.Interval)*time.Second)
+	return true
+}
 
-			log.Printf("Waiting %d seconds before next update...", config.Interval)
-			time.Sleep(time.Duration(config.Interval) * time.Second)
+// resetTimer stops the timer, drains it if needed and resets it to d
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
 		}
 	}
+	timer.Reset(d)
 }
 
 func loadConfig(filename string) (*Config, error) {
@@ -107,7 +160,7 @@ func loadConfig(filename string) (*Config, error) {
 		return nil, fmt.Errorf("failed to load config file %s: %w", configPath, err)
 	}
 
-	log.Printf("Loaded configuration from: %s", configPath)
+	config.path = configPath
 	return config, nil
 }
 
@@ -158,25 +211,3 @@ func findConfigFile(filename string) (string, error) {
 
 	return "", fmt.Errorf("config file not found: %s", filename)
 }
-
-// setupLogging configures logging based on the provided options
-func setupLogging(verbose bool, logFile string) error {
-	// Set log flags
-	if verbose {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else {
-		log.SetFlags(log.LstdFlags)
-	}
-
-	// Setup log output
-	if logFile != "" {
-		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err != nil {
-			return fmt.Errorf("failed to open log file: %w", err)
-		}
-		log.SetOutput(file)
-		log.Printf("Logging to file: %s", logFile)
-	}
-
-	return nil
-}
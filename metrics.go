@@ -0,0 +1,263 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiLatencyBuckets are the histogram buckets for Cloudflare API latency, in seconds
+var apiLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics collects the daemon's Prometheus metrics. Recording is always on
+// and cheap; the values are only exposed when http_listen is configured.
+var metrics = NewMetrics()
+
+// Metrics holds counters and gauges exposed in the Prometheus text format
+type Metrics struct {
+	mu sync.Mutex
+
+	updateCycles    map[string]uint64
+	recordResults   map[[3]string]uint64
+	recordChanges   map[[3]string]uint64
+	providerResults map[[3]string]uint64
+	apiLatency      map[string]*histogram
+	apiRetries      map[string]uint64
+	lastSuccess     time.Time
+	currentIPs      map[string]string
+}
+
+// histogram is a cumulative Prometheus histogram
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewMetrics creates an empty metrics collection
+func NewMetrics() *Metrics {
+	return &Metrics{
+		updateCycles:    make(map[string]uint64),
+		recordResults:   make(map[[3]string]uint64),
+		recordChanges:   make(map[[3]string]uint64),
+		providerResults: make(map[[3]string]uint64),
+		apiLatency:      make(map[string]*histogram),
+		apiRetries:      make(map[string]uint64),
+		currentIPs:      make(map[string]string),
+	}
+}
+
+// ObserveUpdateCycle counts a completed update cycle
+func (m *Metrics) ObserveUpdateCycle(success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.updateCycles[resultLabel(success)]++
+	if success {
+		m.lastSuccess = time.Now()
+	}
+}
+
+// ObserveRecord counts the outcome of checking one record of a domain
+func (m *Metrics) ObserveRecord(domain, recordType string, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordResults[[3]string{domain, recordType, resultLabel(success)}]++
+}
+
+// ObserveRecordChange counts a record that was created, updated or deleted
+func (m *Metrics) ObserveRecordChange(domain, recordType, action string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordChanges[[3]string{domain, recordType, action}]++
+}
+
+// ObserveProvider counts the outcome of querying an IP provider
+func (m *Metrics) ObserveProvider(family, provider string, success bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.providerResults[[3]string{strings.ToLower(family), provider, resultLabel(success)}]++
+}
+
+// ObserveAPILatency records the duration of a Cloudflare API request
+func (m *Metrics) ObserveAPILatency(method string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.apiLatency[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(apiLatencyBuckets))}
+		m.apiLatency[method] = h
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range apiLatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ObserveAPIRetry counts a retried Cloudflare API request by reason
+func (m *Metrics) ObserveAPIRetry(reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiRetries[reason]++
+}
+
+// SetCurrentIP records the detected address of a family ("" if unknown)
+func (m *Metrics) SetCurrentIP(family, ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ip == "" {
+		delete(m.currentIPs, family)
+		return
+	}
+	m.currentIPs[family] = ip
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "cf_ddns_update_cycles_total", "counter", "Completed update cycles by result.")
+	for _, result := range sortedKeys(m.updateCycles) {
+		fmt.Fprintf(&b, "cf_ddns_update_cycles_total{result=%s} %d\n", labelValue(result), m.updateCycles[result])
+	}
+
+	writeHeader(&b, "cf_ddns_record_checks_total", "counter", "Record checks per domain and record type by result.")
+	for _, key := range sortedTripleKeys(m.recordResults) {
+		fmt.Fprintf(&b, "cf_ddns_record_checks_total{domain=%s,type=%s,result=%s} %d\n",
+			labelValue(key[0]), labelValue(key[1]), labelValue(key[2]), m.recordResults[key])
+	}
+
+	writeHeader(&b, "cf_ddns_record_changes_total", "counter", "DNS records changed per domain and record type; action is created, updated or deleted.")
+	for _, key := range sortedTripleKeys(m.recordChanges) {
+		fmt.Fprintf(&b, "cf_ddns_record_changes_total{domain=%s,type=%s,action=%s} %d\n",
+			labelValue(key[0]), labelValue(key[1]), labelValue(key[2]), m.recordChanges[key])
+	}
+
+	writeHeader(&b, "cf_ddns_last_successful_update_timestamp_seconds", "gauge", "Unix time of the last successful update cycle.")
+	lastSuccess := 0.0
+	if !m.lastSuccess.IsZero() {
+		lastSuccess = float64(m.lastSuccess.UnixNano()) / 1e9
+	}
+	fmt.Fprintf(&b, "cf_ddns_last_successful_update_timestamp_seconds %g\n", lastSuccess)
+
+	writeHeader(&b, "cf_ddns_current_ip", "gauge", "Currently detected public address, as a label.")
+	for _, family := range sortedKeys(m.currentIPs) {
+		fmt.Fprintf(&b, "cf_ddns_current_ip{family=%s,ip=%s} 1\n", labelValue(family), labelValue(m.currentIPs[family]))
+	}
+
+	writeHeader(&b, "cf_ddns_ip_provider_requests_total", "counter", "IP provider queries by address family, provider and result.")
+	for _, key := range sortedTripleKeys(m.providerResults) {
+		fmt.Fprintf(&b, "cf_ddns_ip_provider_requests_total{family=%s,provider=%s,result=%s} %d\n",
+			labelValue(key[0]), labelValue(key[1]), labelValue(key[2]), m.providerResults[key])
+	}
+
+	writeHeader(&b, "cf_ddns_cloudflare_api_request_duration_seconds", "histogram", "Cloudflare API request latency by HTTP method.")
+	for _, method := range sortedKeys(m.apiLatency) {
+		h := m.apiLatency[method]
+		for i, bound := range apiLatencyBuckets {
+			fmt.Fprintf(&b, "cf_ddns_cloudflare_api_request_duration_seconds_bucket{method=%s,le=\"%g\"} %d\n",
+				labelValue(method), bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "cf_ddns_cloudflare_api_request_duration_seconds_bucket{method=%s,le=\"+Inf\"} %d\n", labelValue(method), h.count)
+		fmt.Fprintf(&b, "cf_ddns_cloudflare_api_request_duration_seconds_sum{method=%s} %g\n", labelValue(method), h.sum)
+		fmt.Fprintf(&b, "cf_ddns_cloudflare_api_request_duration_seconds_count{method=%s} %d\n", labelValue(method), h.count)
+	}
+
+	writeHeader(&b, "cf_ddns_cloudflare_api_retries_total", "counter", "Retried Cloudflare API requests by reason.")
+	for _, reason := range sortedKeys(m.apiRetries) {
+		fmt.Fprintf(&b, "cf_ddns_cloudflare_api_retries_total{reason=%s} %d\n", labelValue(reason), m.apiRetries[reason])
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// labelValue quotes and escapes a Prometheus label value
+func labelValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// resultLabel converts a success flag to a result label value
+func resultLabel(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
+
+// sortedKeys returns the keys of a string-keyed map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedTripleKeys returns the keys of a label-triple map in order
+func sortedTripleKeys(m map[[3]string]uint64) [][3]string {
+	keys := make([][3]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	return keys
+}
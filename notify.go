@@ -0,0 +1,443 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notification event types
+const (
+	EventRecordChanged   = "record_changed"
+	EventDomainFailed    = "domain_failed"
+	EventDomainRecovered = "domain_recovered"
+)
+
+// Notifier types
+const (
+	NotifierWebhook = "webhook"
+	NotifierSlack   = "slack"
+	NotifierDiscord = "discord"
+	NotifierTeams   = "teams"
+	NotifierNtfy    = "ntfy"
+	NotifierGotify  = "gotify"
+	NotifierEmail   = "email"
+)
+
+// notifyTimeout bounds how long a single notification may take
+const notifyTimeout = 10 * time.Second
+
+// Event describes something worth notifying about
+type Event struct {
+	Type       string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Domain     string    `json:"domain"`
+	RecordType string    `json:"record_type,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	OldContent string    `json:"old_content,omitempty"`
+	NewContent string    `json:"new_content,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Failures   int       `json:"failures,omitempty"`
+	Message    string    `json:"message"`
+}
+
+// Notifier delivers events to a single destination
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Notifications dispatches events to the configured notifiers
+type Notifications struct {
+	targets []notifyTarget
+}
+
+// notifyTarget is a notifier together with the events it wants
+type notifyTarget struct {
+	name     string
+	events   map[string]bool
+	notifier Notifier
+}
+
+// NewNotifications creates notifiers from an already validated configuration
+func NewNotifications(config NotificationsConfig) (*Notifications, error) {
+	client := &http.Client{Timeout: notifyTimeout}
+
+	n := &Notifications{}
+	for i, target := range config.Notifiers {
+		notifier, err := newNotifier(target, client)
+		if err != nil {
+			return nil, fmt.Errorf("notifications.notifiers[%d]: %w", i, err)
+		}
+
+		events := make(map[string]bool, len(target.Events))
+		for _, event := range target.Events {
+			events[event] = true
+		}
+
+		n.targets = append(n.targets, notifyTarget{
+			name:     fmt.Sprintf("%s notifier #%d", target.Type, i+1),
+			events:   events,
+			notifier: notifier,
+		})
+	}
+	return n, nil
+}
+
+// Notify sends the event to every notifier subscribed to its type, all at
+// once so a slow notifier holds up the update for at most notifyTimeout.
+// Failures are logged and never interrupt the update.
+func (n *Notifications) Notify(ctx context.Context, event Event) {
+	if n == nil || len(n.targets) == 0 {
+		return
+	}
+
+	event.Time = time.Now()
+	if event.Message == "" {
+		event.Message = event.defaultMessage()
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range n.targets {
+		if len(target.events) > 0 && !target.events[event.Type] {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			notifyCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+			defer cancel()
+			if err := target.notifier.Notify(notifyCtx, event); err != nil {
+				slog.Warn("Failed to send notification", "event", event.Type, "notifier", target.name, "domain", event.Domain, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// defaultMessage builds a one-line human readable summary of the event
+func (e Event) defaultMessage() string {
+	switch e.Type {
+	case EventRecordChanged:
+		switch {
+		case e.Action == "deleted":
+			return fmt.Sprintf("Deleted %s record for %s: %s", e.RecordType, e.Domain, e.OldContent)
+		case e.OldContent == "":
+			return fmt.Sprintf("Created %s record for %s: %s", e.RecordType, e.Domain, e.NewContent)
+		}
+		return fmt.Sprintf("Updated %s record for %s: %s -> %s", e.RecordType, e.Domain, e.OldContent, e.NewContent)
+	case EventDomainFailed:
+		return fmt.Sprintf("Updating %s failed %d times in a row: %s", e.Domain, e.Failures, e.Error)
+	case EventDomainRecovered:
+		return fmt.Sprintf("Updating %s succeeded again after %d failures", e.Domain, e.Failures)
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.Domain)
+}
+
+// newNotifier creates a notifier of the configured type
+func newNotifier(config NotifierConfig, client *http.Client) (Notifier, error) {
+	var tmpl *template.Template
+	if config.Template != "" {
+		var err error
+		tmpl, err = parseNotificationTemplate(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	switch config.Type {
+	case NotifierWebhook:
+		return &webhookNotifier{url: config.URL, headers: config.Headers, template: tmpl, client: client}, nil
+	case NotifierSlack:
+		return &chatNotifier{url: config.URL, field: "text", template: tmpl, client: client}, nil
+	case NotifierDiscord:
+		return &chatNotifier{url: config.URL, field: "content", template: tmpl, client: client}, nil
+	case NotifierTeams:
+		return &chatNotifier{url: config.URL, field: "text", template: tmpl, client: client}, nil
+	case NotifierNtfy:
+		return &ntfyNotifier{url: config.URL, token: config.Token, template: tmpl, client: client}, nil
+	case NotifierGotify:
+		return &gotifyNotifier{url: config.URL, token: config.Token, template: tmpl, client: client}, nil
+	case NotifierEmail:
+		return &emailNotifier{config: config, template: tmpl}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", config.Type)
+}
+
+// parseNotificationTemplate parses a notification template. Besides the
+// standard functions, {{json .Field}} writes a value as a JSON literal so
+// templated JSON bodies stay valid.
+func parseNotificationTemplate(text string) (*template.Template, error) {
+	return template.New("notification").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+// renderMessage renders the template for an event, or returns its default message
+func renderMessage(tmpl *template.Template, event Event) (string, error) {
+	if tmpl == nil {
+		return event.Message, nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// postNotification sends a notification request and checks the status
+func postNotification(ctx context.Context, client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, url)
+	}
+	return nil
+}
+
+// webhookNotifier posts the event as JSON, or the rendered template as the body
+type webhookNotifier struct {
+	url      string
+	headers  map[string]string
+	template *template.Template
+	client   *http.Client
+}
+
+// Notify posts the event
+func (n *webhookNotifier) Notify(ctx context.Context, event Event) error {
+	var body []byte
+	if n.template != nil {
+		rendered, err := renderMessage(n.template, event)
+		if err != nil {
+			return err
+		}
+		body = []byte(rendered)
+	} else {
+		var err error
+		if body, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+
+	return postNotification(ctx, n.client, n.url, "application/json", body, n.headers)
+}
+
+// chatNotifier posts {"<field>": message} as used by Slack, Discord and Teams
+// incoming webhooks
+type chatNotifier struct {
+	url      string
+	field    string
+	template *template.Template
+	client   *http.Client
+}
+
+// Notify posts the message
+func (n *chatNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := renderMessage(n.template, event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{n.field: message})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, n.client, n.url, "application/json", body, nil)
+}
+
+// ntfyNotifier publishes the message to an ntfy topic URL
+type ntfyNotifier struct {
+	url      string
+	token    string
+	template *template.Template
+	client   *http.Client
+}
+
+// Notify publishes the message with a title and tag
+func (n *ntfyNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := renderMessage(n.template, event)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"Title": AppName + ": " + event.Domain,
+		"Tags":  event.Type,
+	}
+	if event.Type == EventDomainFailed {
+		headers["Priority"] = "high"
+	}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+
+	return postNotification(ctx, n.client, n.url, "text/plain; charset=utf-8", []byte(message), headers)
+}
+
+// gotifyNotifier posts the message to a Gotify server
+type gotifyNotifier struct {
+	url      string
+	token    string
+	template *template.Template
+	client   *http.Client
+}
+
+// Notify posts the message to the /message endpoint
+func (n *gotifyNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := renderMessage(n.template, event)
+	if err != nil {
+		return err
+	}
+
+	priority := 5
+	if event.Type == EventDomainFailed {
+		priority = 8
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    AppName + ": " + event.Domain,
+		"message":  message,
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(n.url, "/") + "/message"
+	return postNotification(ctx, n.client, url, "application/json", body, map[string]string{"X-Gotify-Key": n.token})
+}
+
+// emailNotifier sends the message over SMTP
+type emailNotifier struct {
+	config   NotifierConfig
+	template *template.Template
+}
+
+// Notify sends a plain text email; STARTTLS is used when the server offers it
+func (n *emailNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := renderMessage(n.template, event)
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s: %s %s\r\n", AppName, event.Domain, strings.ReplaceAll(event.Type, "_", " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(message)
+	msg.WriteString("\r\n")
+
+	address := net.JoinHostPort(n.config.SMTPHost, strconv.Itoa(n.config.SMTPPort))
+	dialer := net.Dialer{Timeout: notifyTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// net/smtp has no context support: bound every read and write by the
+	// deadline and close the connection if ctx is cancelled before it
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := n.send(conn, []byte(msg.String())); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// send delivers a message over an established SMTP connection
+func (n *emailNotifier) send(conn net.Conn, msg []byte) error {
+	client, err := smtp.NewClient(conn, n.config.SMTPHost)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.config.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if n.config.Username != "" {
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
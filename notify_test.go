@@ -0,0 +1,476 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifyRequest is a request received by a fake notification endpoint
+type notifyRequest struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// notifyEndpoint is a fake notification service recording what it receives
+type notifyEndpoint struct {
+	mu       sync.Mutex
+	requests []notifyRequest
+	server   *httptest.Server
+}
+
+// startNotifyEndpoint runs a fake notification service answering with status
+func startNotifyEndpoint(t *testing.T, status int) *notifyEndpoint {
+	t.Helper()
+	e := &notifyEndpoint{}
+	e.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		e.requests = append(e.requests, notifyRequest{path: r.URL.Path, header: r.Header, body: string(body)})
+		e.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(e.server.Close)
+	return e
+}
+
+// received returns the requests received so far
+func (e *notifyEndpoint) received() []notifyRequest {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]notifyRequest(nil), e.requests...)
+}
+
+// only returns the single request received, failing the test otherwise
+func (e *notifyEndpoint) only(t *testing.T) notifyRequest {
+	t.Helper()
+	requests := e.received()
+	if len(requests) != 1 {
+		t.Fatalf("received %d requests, want 1", len(requests))
+	}
+	return requests[0]
+}
+
+// testEvent is a record change as the updater reports it
+var testEvent = Event{
+	Type:       EventRecordChanged,
+	Time:       time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	Domain:     "home.example.com",
+	RecordType: "A",
+	Action:     "updated",
+	OldContent: "198.51.100.1",
+	NewContent: "203.0.113.1",
+	Message:    "Updated A record for home.example.com: 198.51.100.1 -> 203.0.113.1",
+}
+
+// notify sends event through a notifier created from config
+func notify(t *testing.T, config NotifierConfig, event Event) error {
+	t.Helper()
+	notifier, err := newNotifier(config, &http.Client{Timeout: notifyTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier.Notify(context.Background(), event)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	endpoint := startNotifyEndpoint(t, http.StatusOK)
+	config := NotifierConfig{
+		Type:    NotifierWebhook,
+		URL:     endpoint.server.URL + "/hook",
+		Headers: map[string]string{"Authorization": "Bearer secret", "X-Source": "ddns"},
+	}
+	if err := notify(t, config, testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	req := endpoint.only(t)
+	if req.path != "/hook" {
+		t.Errorf("path = %q, want /hook", req.path)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": "Bearer secret",
+		"X-Source":      "ddns",
+	} {
+		if got := req.header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	var got Event
+	if err := json.Unmarshal([]byte(req.body), &got); err != nil {
+		t.Fatalf("body %q is not an event: %v", req.body, err)
+	}
+	if got != testEvent {
+		t.Errorf("event = %+v, want %+v", got, testEvent)
+	}
+}
+
+func TestWebhookNotifierTemplate(t *testing.T) {
+	endpoint := startNotifyEndpoint(t, http.StatusOK)
+	config := NotifierConfig{
+		Type:     NotifierWebhook,
+		URL:      endpoint.server.URL,
+		Template: `{"domain": {{json .Domain}}, "ip": {{json .NewContent}}, "note": {{json .Message}}}`,
+	}
+	event := testEvent
+	event.Message = `quote " and newline` + "\n"
+	if err := notify(t, config, event); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]string
+	body := endpoint.only(t).body
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("templated body %q is not valid JSON: %v", body, err)
+	}
+	want := map[string]string{"domain": "home.example.com", "ip": "203.0.113.1", "note": event.Message}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestChatNotifiers(t *testing.T) {
+	tests := []struct {
+		notifierType string
+		template     string
+		field        string
+		want         string
+	}{
+		{NotifierSlack, "", "text", testEvent.Message},
+		{NotifierDiscord, "", "content", testEvent.Message},
+		{NotifierTeams, "", "text", testEvent.Message},
+		{NotifierSlack, "{{.Domain}} is now {{.NewContent}}", "text", "home.example.com is now 203.0.113.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notifierType, func(t *testing.T) {
+			endpoint := startNotifyEndpoint(t, http.StatusNoContent)
+			config := NotifierConfig{Type: tt.notifierType, URL: endpoint.server.URL, Template: tt.template}
+			if err := notify(t, config, testEvent); err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]string
+			body := endpoint.only(t).body
+			if err := json.Unmarshal([]byte(body), &got); err != nil {
+				t.Fatalf("body %q: %v", body, err)
+			}
+			if len(got) != 1 || got[tt.field] != tt.want {
+				t.Errorf("payload = %v, want {%q: %q}", got, tt.field, tt.want)
+			}
+		})
+	}
+}
+
+func TestNtfyNotifier(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  string
+		token  string
+		header map[string]string
+	}{
+		{"record changed", EventRecordChanged, "", map[string]string{
+			"Title":         AppName + ": home.example.com",
+			"Tags":          EventRecordChanged,
+			"Priority":      "",
+			"Authorization": "",
+		}},
+		{"domain failed with token", EventDomainFailed, "tk_secret", map[string]string{
+			"Tags":          EventDomainFailed,
+			"Priority":      "high",
+			"Authorization": "Bearer tk_secret",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := startNotifyEndpoint(t, http.StatusOK)
+			config := NotifierConfig{Type: NotifierNtfy, URL: endpoint.server.URL + "/ddns", Token: tt.token}
+			event := testEvent
+			event.Type = tt.event
+			if err := notify(t, config, event); err != nil {
+				t.Fatal(err)
+			}
+
+			req := endpoint.only(t)
+			if req.path != "/ddns" || req.body != testEvent.Message {
+				t.Errorf("got %s %q, want /ddns %q", req.path, req.body, testEvent.Message)
+			}
+			if got := req.header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+				t.Errorf("Content-Type = %q, want text/plain", got)
+			}
+			for name, want := range tt.header {
+				if got := req.header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGotifyNotifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffix   string
+		event    string
+		priority float64
+	}{
+		{"record changed", "", EventRecordChanged, 5},
+		{"domain failed, trailing slash", "/", EventDomainFailed, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := startNotifyEndpoint(t, http.StatusOK)
+			config := NotifierConfig{Type: NotifierGotify, URL: endpoint.server.URL + tt.suffix, Token: "app-token"}
+			event := testEvent
+			event.Type = tt.event
+			if err := notify(t, config, event); err != nil {
+				t.Fatal(err)
+			}
+
+			req := endpoint.only(t)
+			if req.path != "/message" {
+				t.Errorf("path = %q, want /message", req.path)
+			}
+			if got := req.header.Get("X-Gotify-Key"); got != "app-token" {
+				t.Errorf("X-Gotify-Key = %q, want app-token", got)
+			}
+
+			var got map[string]any
+			if err := json.Unmarshal([]byte(req.body), &got); err != nil {
+				t.Fatalf("body %q: %v", req.body, err)
+			}
+			if got["title"] != AppName+": home.example.com" || got["message"] != testEvent.Message || got["priority"] != tt.priority {
+				t.Errorf("payload = %v", got)
+			}
+		})
+	}
+}
+
+func TestNotifierHTTPErrors(t *testing.T) {
+	for _, notifierType := range []string{NotifierWebhook, NotifierSlack, NotifierNtfy, NotifierGotify} {
+		t.Run(notifierType, func(t *testing.T) {
+			endpoint := startNotifyEndpoint(t, http.StatusForbidden)
+			err := notify(t, NotifierConfig{Type: notifierType, URL: endpoint.server.URL}, testEvent)
+			if err == nil || !strings.Contains(err.Error(), "HTTP 403") {
+				t.Errorf("error = %v, want HTTP 403", err)
+			}
+		})
+	}
+}
+
+func TestNotificationsEventFilter(t *testing.T) {
+	all := startNotifyEndpoint(t, http.StatusOK)
+	failures := startNotifyEndpoint(t, http.StatusOK)
+	changes := startNotifyEndpoint(t, http.StatusOK)
+
+	notifications, err := NewNotifications(NotificationsConfig{Notifiers: []NotifierConfig{
+		{Type: NotifierWebhook, URL: all.server.URL},
+		{Type: NotifierWebhook, URL: failures.server.URL, Events: []string{EventDomainFailed, EventDomainRecovered}},
+		{Type: NotifierWebhook, URL: changes.server.URL, Events: []string{EventRecordChanged}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, eventType := range []string{EventRecordChanged, EventDomainFailed, EventDomainRecovered} {
+		notifications.Notify(context.Background(), Event{Type: eventType, Domain: "home.example.com"})
+	}
+
+	for _, tt := range []struct {
+		name     string
+		endpoint *notifyEndpoint
+		want     []string
+	}{
+		{"unfiltered", all, []string{EventRecordChanged, EventDomainFailed, EventDomainRecovered}},
+		{"failures", failures, []string{EventDomainFailed, EventDomainRecovered}},
+		{"changes", changes, []string{EventRecordChanged}},
+	} {
+		var got []string
+		for _, req := range tt.endpoint.received() {
+			var event Event
+			json.Unmarshal([]byte(req.body), &event)
+			got = append(got, event.Type)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s notifier got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNotificationsDefaultMessage(t *testing.T) {
+	endpoint := startNotifyEndpoint(t, http.StatusOK)
+	notifications, err := NewNotifications(NotificationsConfig{Notifiers: []NotifierConfig{
+		{Type: NotifierSlack, URL: endpoint.server.URL},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications.Notify(context.Background(), Event{Type: EventDomainFailed, Domain: "home.example.com", Error: "boom", Failures: 3})
+
+	want := `{"text":"Updating home.example.com failed 3 times in a row: boom"}`
+	if got := endpoint.only(t).body; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+// startSMTPServer runs a minimal SMTP server on localhost that stores the
+// message data it receives. With hang set it accepts connections but never
+// answers.
+func startSMTPServer(t *testing.T, hang bool) (port int, messages <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if hang {
+				t.Cleanup(func() { conn.Close() })
+				continue
+			}
+			go serveSMTP(conn, received)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+// serveSMTP answers one SMTP session without extensions
+func serveSMTP(conn net.Conn, received chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, "220 localhost ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line + " x")[0]); command {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			io.WriteString(conn, "250 OK\r\n")
+		case "DATA":
+			io.WriteString(conn, "354 Go ahead\r\n")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			received <- data.String()
+			io.WriteString(conn, "250 Queued\r\n")
+		case "QUIT":
+			io.WriteString(conn, "221 Bye\r\n")
+			return
+		default:
+			io.WriteString(conn, "502 Not implemented\r\n")
+		}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	port, messages := startSMTPServer(t, false)
+	config := NotifierConfig{
+		Type:     NotifierEmail,
+		SMTPHost: "127.0.0.1",
+		SMTPPort: port,
+		From:     "ddns@example.com",
+		To:       []string{"admin@example.com", "ops@example.com"},
+	}
+	if err := notify(t, config, testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-messages
+	for _, want := range []string{
+		"From: ddns@example.com\r\n",
+		"To: admin@example.com, ops@example.com\r\n",
+		"Subject: " + AppName + ": home.example.com record changed\r\n",
+		"\r\n\r\n" + testEvent.Message + "\r\n",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message is missing %q:\n%s", want, message)
+		}
+	}
+}
+
+func TestEmailNotifierHungServer(t *testing.T) {
+	port, _ := startSMTPServer(t, true)
+	notifier := &emailNotifier{config: NotifierConfig{
+		SMTPHost: "127.0.0.1",
+		SMTPPort: port,
+		From:     "ddns@example.com",
+		To:       []string{"admin@example.com"},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := notifier.Notify(ctx, testEvent)
+	if err == nil {
+		t.Fatal("expected an error from a server that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Notify took %v despite the 200ms deadline", elapsed)
+	}
+}
+
+func TestEmailNotifierCancelled(t *testing.T) {
+	port, _ := startSMTPServer(t, true)
+	notifier := &emailNotifier{config: NotifierConfig{
+		SMTPHost: "127.0.0.1",
+		SMTPPort: port,
+		From:     "ddns@example.com",
+		To:       []string{"admin@example.com"},
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := notifier.Notify(ctx, testEvent); err != context.Canceled {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Planned change actions
+const (
+	PlanCreate    = "create"
+	PlanUpdate    = "update"
+	PlanDelete    = "delete"
+	PlanUnchanged = "unchanged"
+	PlanUnknown   = "unknown" // no address was detected for the record type
+)
+
+// Plan lists the DNS changes an update would make, built in dry-run mode
+type Plan struct {
+	Changes []PlannedChange
+}
+
+// PlannedChange is the action an update would take for a single record
+type PlannedChange struct {
+	Action  string
+	ZoneID  string
+	Current *DNSRecord // nil when the record would be created
+	Desired DNSRecord
+}
+
+// add records the action planned for a record
+func (p *Plan) add(action, zoneID string, current *DNSRecord, desired DNSRecord) {
+	p.Changes = append(p.Changes, PlannedChange{
+		Action:  action,
+		ZoneID:  zoneID,
+		Current: current,
+		Desired: desired,
+	})
+}
+
+// Pending returns true if any record would be created, updated or deleted
+func (p *Plan) Pending() bool {
+	for _, change := range p.Changes {
+		if change.Action != PlanUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the plan as a table followed by a summary line
+func (p *Plan) Print(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ACTION\tDOMAIN\tTYPE\tCURRENT\tDESIRED\tDETAILS")
+
+	counts := make(map[string]int)
+	for _, change := range p.Changes {
+		counts[change.Action]++
+
+		current := "-"
+		if change.Current != nil {
+			current = change.Current.Content
+		}
+		desired, details := change.Desired.Content, change.details()
+		if change.Action == PlanDelete || change.Action == PlanUnknown {
+			desired, details = "-", "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			change.Action, change.Desired.Name, change.Desired.Type,
+			current, desired, details)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("Plan: %d to create, %d to update, %d to delete, %d unchanged",
+		counts[PlanCreate], counts[PlanUpdate], counts[PlanDelete], counts[PlanUnchanged])
+	if counts[PlanUnknown] > 0 {
+		summary += fmt.Sprintf(", %d unknown", counts[PlanUnknown])
+	}
+	_, err := fmt.Fprintf(w, "\n%s\n", summary)
+	return err
+}
+
+// PrintStatus writes each record's current Cloudflare content next to the
+// desired content as a table
+func (p *Plan) PrintStatus(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DOMAIN\tTYPE\tCLOUDFLARE\tDETECTED\tSTATUS")
+
+	for _, change := range p.Changes {
+		current, status := "-", "missing"
+		if change.Current != nil {
+			current = change.Current.Content
+			status = "out of date"
+		}
+		detected := change.Desired.Content
+		switch change.Action {
+		case PlanUnchanged:
+			status = "up to date"
+		case PlanDelete:
+			detected, status = "-", "extra"
+		case PlanUnknown:
+			detected, status = "-", "unknown"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n",
+			change.Desired.Name, change.Desired.Type, current, detected, status)
+	}
+	return table.Flush()
+}
+
+// details describes the TTL and proxy settings, showing old and new values
+// when they change
+func (c PlannedChange) details() string {
+	ttl := ttlString(c.Desired.TTL)
+	proxied := strconv.FormatBool(c.Desired.Proxied)
+	if c.Current != nil {
+		if c.Current.TTL != c.Desired.TTL {
+			ttl = ttlString(c.Current.TTL) + " -> " + ttl
+		}
+		if c.Current.Proxied != c.Desired.Proxied {
+			proxied = strconv.FormatBool(c.Current.Proxied) + " -> " + proxied
+		}
+	}
+	return strings.Join([]string{"ttl=" + ttl, "proxied=" + proxied}, " ")
+}
+
+// ttlString formats a TTL, where 1 means automatic
+func ttlString(ttl int) string {
+	if ttl == 1 {
+		return "auto"
+	}
+	return strconv.Itoa(ttl)
+}
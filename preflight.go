@@ -0,0 +1,218 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// PreflightResult is the outcome of the startup checks for one domain
+type PreflightResult struct {
+	Domain      string
+	Credentials string // "" for the default [cloudflare] section
+	Zone        string
+	ZoneID      string
+	Err         error
+}
+
+// Preflight checks, before any update, that every set of credentials is
+// accepted by Cloudflare and that each domain's zone is reachable with DNS
+// edit permission. Zones found by name are cached in the state.
+func (u *DDNSUpdater) Preflight(ctx context.Context) []PreflightResult {
+	results := make([]PreflightResult, len(u.config.Domains))
+	for i, domain := range u.config.Domains {
+		results[i] = PreflightResult{Domain: domain.Name, Credentials: domain.Credentials}
+	}
+
+	names := make([]string, 0, len(u.cfClients))
+	for name := range u.cfClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cf := u.cfClients[name]
+
+		zones, err := u.preflightCredentials(ctx, cf)
+		for i, domain := range u.config.Domains {
+			if domain.Credentials != name {
+				continue
+			}
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			u.preflightDomain(domain, zones, &results[i])
+		}
+	}
+
+	for i := range results {
+		if _, ok := u.cfClients[results[i].Credentials]; !ok {
+			results[i].Err = fmt.Errorf("no Cloudflare credentials available")
+		}
+	}
+	return results
+}
+
+// preflightCredentials verifies a set of credentials and lists its zones
+func (u *DDNSUpdater) preflightCredentials(ctx context.Context, cf *CloudflareClient) ([]Zone, error) {
+	if err := cf.VerifyCredentials(ctx); err != nil {
+		return nil, err
+	}
+
+	zones, err := cf.ListZones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list zones: %w", err)
+	}
+	return zones, nil
+}
+
+// preflightDomain finds the zone of a domain among the accessible zones and
+// checks it allows editing DNS records. A zone_id on the domain must name a
+// zone containing it; the zone_id of its credentials is used if it does.
+func (u *DDNSUpdater) preflightDomain(domain DomainConfig, zones []Zone, result *PreflightResult) {
+	var zone *Zone
+	if domain.ZoneID != "" {
+		zone = zoneByID(zones, domain.ZoneID)
+		if zone == nil {
+			result.ZoneID = domain.ZoneID
+			result.Err = fmt.Errorf("zone %s is not accessible with these credentials", domain.ZoneID)
+			return
+		}
+		if !inZone(domain.Name, zone.Name) {
+			result.Zone, result.ZoneID = zone.Name, zone.ID
+			result.Err = fmt.Errorf("%s is not inside zone %s", domain.Name, zone.Name)
+			return
+		}
+	} else if credZone := zoneByID(zones, u.config.CredentialsFor(domain).ZoneID); credZone != nil && inZone(domain.Name, credZone.Name) {
+		zone = credZone
+		u.state.SetZone(domain.Name, zone.ID)
+	} else {
+		zone = zoneForDomain(zones, domain.Name)
+		if zone == nil {
+			result.Err = fmt.Errorf("%w: no accessible zone contains %s", ErrZoneNotFound, domain.Name)
+			return
+		}
+		u.state.SetZone(domain.Name, zone.ID)
+	}
+
+	result.Zone = zone.Name
+	result.ZoneID = zone.ID
+	if !zone.CanEditDNS() {
+		result.Err = fmt.Errorf("missing DNS edit permission for zone %s", zone.Name)
+	}
+}
+
+// zoneByID returns the zone with the given ID, or nil
+func zoneByID(zones []Zone, zoneID string) *Zone {
+	for i := range zones {
+		if zoneID != "" && zones[i].ID == zoneID {
+			return &zones[i]
+		}
+	}
+	return nil
+}
+
+// inZone returns true if the domain is the zone apex or a name inside it
+func inZone(domain, zone string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+	return domain == zone || strings.HasSuffix(domain, "."+zone)
+}
+
+// zoneForDomain returns the most specific zone containing the domain
+func zoneForDomain(zones []Zone, domain string) *Zone {
+	var best *Zone
+	for i := range zones {
+		if !inZone(domain, zones[i].Name) {
+			continue
+		}
+		if best == nil || len(zones[i].Name) > len(best.Name) {
+			best = &zones[i]
+		}
+	}
+	return best
+}
+
+// preflightFailed returns true if any check failed for a reason other than
+// Cloudflare being unreachable, which may only be temporary
+func preflightFailed(results []PreflightResult) bool {
+	for _, result := range results {
+		if result.Err != nil && !isUnreachable(result.Err) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnreachable returns true if err means the request never got a response
+func isUnreachable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// logPreflight logs the outcome of the checks for each domain
+func logPreflight(results []PreflightResult) {
+	for _, result := range results {
+		switch {
+		case result.Err != nil && isUnreachable(result.Err):
+			slog.Warn("Preflight check skipped, Cloudflare is unreachable", "domain", result.Domain, "error", result.Err)
+		case result.Err != nil:
+			slog.Error("Preflight check failed", "domain", result.Domain, "zone_id", result.ZoneID, "error", result.Err)
+		default:
+			slog.Debug("Preflight check passed", "domain", result.Domain, "zone", result.Zone, "zone_id", result.ZoneID)
+		}
+	}
+}
+
+// printPreflight writes the outcome of the checks as a table
+func printPreflight(w io.Writer, results []PreflightResult) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DOMAIN\tCREDENTIALS\tZONE\tZONE ID\tRESULT")
+
+	for _, result := range results {
+		credentials := "[cloudflare]"
+		if result.Credentials != "" {
+			credentials = result.Credentials
+		}
+		outcome := "ok"
+		if result.Err != nil {
+			outcome = result.Err.Error()
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n",
+			result.Domain, credentials, orDash(result.Zone), orDash(result.ZoneID), outcome)
+	}
+	return table.Flush()
+}
+
+// orDash returns s, or "-" when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
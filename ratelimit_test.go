@@ -0,0 +1,107 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	// 100 per hour allows a burst of 10 and then hardly any refill
+	limiter := newRateLimiter(100, time.Hour)
+	for i := range 10 {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := limiter.Wait(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("request %d within the burst: %v", i+1, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the request after the burst to block until the deadline", err)
+	}
+}
+
+func TestRateLimiterReleases(t *testing.T) {
+	// 20 per second: a burst of 2, then one token every 1/18s
+	limiter := newRateLimiter(20, time.Second)
+	for range 2 {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond || elapsed > time.Second {
+		t.Errorf("waited %v for the next token, want about 55ms", elapsed)
+	}
+}
+
+func TestRateLimiterCancelReturnsToken(t *testing.T) {
+	// A burst of 1 refilled once per 10s
+	limiter := newRateLimiter(2, 10*time.Second)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := limiter.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+
+	// The cancelled request must not leave the bucket in debt
+	limiter.mu.Lock()
+	tokens := limiter.tokens
+	limiter.mu.Unlock()
+	if tokens < -0.1 {
+		t.Errorf("tokens = %v after the cancelled wait, want the token given back", tokens)
+	}
+}
+
+func TestRateLimiterQueuesConcurrentCallers(t *testing.T) {
+	// 10 per second: a burst of 1, then one token every 1/9s
+	limiter := newRateLimiter(10, time.Second)
+	start := time.Now()
+
+	done := make(chan error, 3)
+	for range 3 {
+		go func() { done <- limiter.Wait(context.Background()) }()
+	}
+	for range 3 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second and third callers queue behind each other instead of all
+	// taking the first refilled token
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("three requests took %v, want at least two refill intervals", elapsed)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// State holds what the updater last knew about Cloudflare, so unchanged
+// IPs don't require any API calls
+type State struct {
+	// Time of the last full reconcile against the Cloudflare API
+	LastReconcile time.Time `json:"last_reconcile"`
+
+	// Resolved zone IDs keyed by domain name
+	Zones map[string]string `json:"zones"`
+
+	// Last known records keyed by stateKey(name, type)
+	Records map[string]RecordState `json:"records"`
+
+	path  string
+	dirty bool
+}
+
+// RecordState is the last known state of a single DNS record, or of a set
+// of records published from several WAN sources
+type RecordState struct {
+	ZoneID    string    `json:"zone_id"`
+	RecordID  string    `json:"record_id"`
+	Content   string    `json:"content"`
+	Contents  []string  `json:"contents,omitempty"`
+	TTL       int       `json:"ttl"`
+	Proxied   bool      `json:"proxied"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewState creates an empty state that is saved to path ("" = memory only)
+func NewState(path string) *State {
+	return &State{
+		Zones:   make(map[string]string),
+		Records: make(map[string]RecordState),
+		path:    path,
+	}
+}
+
+// LoadState reads the state file, returning an empty state if it doesn't exist
+func LoadState(path string) (*State, error) {
+	state := NewState(path)
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return NewState(path), fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state.Zones == nil {
+		state.Zones = make(map[string]string)
+	}
+	if state.Records == nil {
+		state.Records = make(map[string]RecordState)
+	}
+
+	return state, nil
+}
+
+// Save writes the state file if anything changed since the last save
+func (s *State) Save() error {
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// ReconcileDue returns true if a full reconcile should be performed
+func (s *State) ReconcileDue(interval time.Duration) bool {
+	return time.Since(s.LastReconcile) >= interval
+}
+
+// MarkReconciled records that a full reconcile has just completed
+func (s *State) MarkReconciled() {
+	s.LastReconcile = time.Now()
+	s.dirty = true
+}
+
+// Zone returns the cached zone ID for a domain
+func (s *State) Zone(domainName string) (string, bool) {
+	zoneID, ok := s.Zones[domainName]
+	return zoneID, ok
+}
+
+// SetZone caches the zone ID for a domain
+func (s *State) SetZone(domainName, zoneID string) {
+	if s.Zones[domainName] == zoneID {
+		return
+	}
+	s.Zones[domainName] = zoneID
+	s.dirty = true
+}
+
+// ForgetZone drops the cached zone ID for a domain
+func (s *State) ForgetZone(domainName string) {
+	if _, ok := s.Zones[domainName]; !ok {
+		return
+	}
+	delete(s.Zones, domainName)
+	s.dirty = true
+}
+
+// Record returns the last known state of a record
+func (s *State) Record(name, recordType string) (RecordState, bool) {
+	record, ok := s.Records[stateKey(name, recordType)]
+	return record, ok
+}
+
+// SetRecord stores the last known state of a record
+func (s *State) SetRecord(zoneID string, record DNSRecord) {
+	s.Records[stateKey(record.Name, record.Type)] = RecordState{
+		ZoneID:    zoneID,
+		RecordID:  record.ID,
+		Content:   record.Content,
+		TTL:       record.TTL,
+		Proxied:   record.Proxied,
+		UpdatedAt: time.Now(),
+	}
+	s.dirty = true
+}
+
+// SetRecordSet stores the last known state of the records published for a
+// name and type from several WAN sources
+func (s *State) SetRecordSet(zoneID string, records []DNSRecord) {
+	first := records[0]
+	s.Records[stateKey(first.Name, first.Type)] = RecordState{
+		ZoneID:    zoneID,
+		Contents:  recordContents(records),
+		TTL:       first.TTL,
+		Proxied:   first.Proxied,
+		UpdatedAt: time.Now(),
+	}
+	s.dirty = true
+}
+
+// ForgetRecord drops the known state of a record, forcing an API lookup
+func (s *State) ForgetRecord(name, recordType string) {
+	key := stateKey(name, recordType)
+	if _, ok := s.Records[key]; !ok {
+		return
+	}
+	delete(s.Records, key)
+	s.dirty = true
+}
+
+// Matches returns true if the record state already has the desired settings
+func (r RecordState) Matches(zoneID string, record DNSRecord) bool {
+	return r.ZoneID == zoneID &&
+		r.Content == record.Content &&
+		r.TTL == record.TTL &&
+		r.Proxied == record.Proxied
+}
+
+// MatchesSet returns true if the record state already has the desired set
+// of records
+func (r RecordState) MatchesSet(zoneID string, records []DNSRecord) bool {
+	first := records[0]
+	return r.ZoneID == zoneID &&
+		slices.Equal(r.Contents, recordContents(records)) &&
+		r.TTL == first.TTL &&
+		r.Proxied == first.Proxied
+}
+
+// recordContents returns the sorted contents of records
+func recordContents(records []DNSRecord) []string {
+	contents := make([]string, len(records))
+	for i, record := range records {
+		contents[i] = record.Content
+	}
+	slices.Sort(contents)
+	return contents
+}
+
+// stateKey builds the map key for a record
+func stateKey(name, recordType string) string {
+	return strings.ToLower(name) + "/" + recordType
+}
@@ -2,9 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"net"
+	"log/slog"
+	"slices"
 	"strings"
 	"time"
 )
@@ -12,35 +13,181 @@ import (
 // DDNSUpdater handles the DNS update process
 type DDNSUpdater struct {
 	config     *Config
-	cfClient   *CloudflareClient
+	cfClients  map[string]*CloudflareClient
 	ipDetector *IPDetector
-	verbose    bool
+	state      *State
+	health     *Health
+	notifier   *Notifications
+
+	// failures counts consecutive failed updates per domain
+	failures map[string]int
+
+	// reconciling is true while a cycle bypasses the state cache
+	reconciling bool
+
+	// plan collects the intended changes instead of applying them while
+	// running in dry-run mode
+	plan *Plan
+
+	// wanDetectors detect the address of each WAN source, keyed by name
+	wanDetectors map[string]*IPDetector
+
+	// wanIPs holds the addresses detected for the WAN sources during the
+	// current cycle, keyed by "<wan>/<record type>" and empty when the
+	// source is unhealthy
+	wanIPs map[string]string
+
+	// detectionErrs holds why IP detection failed in the current cycle,
+	// keyed by record type. Domains left without an address fail.
+	detectionErrs map[string]error
 }
 
 // NewDDNSUpdater creates a new DDNS updater
-func NewDDNSUpdater(config *Config, verbose bool) *DDNSUpdater {
+func NewDDNSUpdater(config *Config) *DDNSUpdater {
+	state, err := LoadState(config.StateFile)
+	if err != nil {
+		slog.Warn("Starting with empty state", "error", err)
+	}
+
 	return &DDNSUpdater{
 		config:     config,
-		cfClient:   NewCloudflareClient(config.Cloudflare),
-		ipDetector: NewIPDetector(),
-		verbose:    verbose || config.Verbose,
+		notifier:   newNotifications(config),
+		failures:   make(map[string]int),
+		cfClients:  newCloudflareClients(config, nil),
+		ipDetector: NewIPDetector(config),
+		state:      state,
+		health:     NewHealth(time.Duration(config.Interval) * time.Second),
+
+		wanDetectors: newWANDetectors(config),
+	}
+}
+
+// Reload swaps in a new, already validated configuration. It must not be
+// called while Update is running.
+func (u *DDNSUpdater) Reload(config *Config) {
+	if config.StateFile != u.config.StateFile {
+		state, err := LoadState(config.StateFile)
+		if err != nil {
+			slog.Warn("Starting with empty state", "error", err)
+		}
+		u.state = state
+	}
+
+	u.config = config
+	u.cfClients = newCloudflareClients(config, u.cfClients)
+	u.ipDetector = NewIPDetector(config)
+	u.wanDetectors = newWANDetectors(config)
+	u.notifier = newNotifications(config)
+}
+
+// newWANDetectors creates one IP detector per WAN source, keyed by name
+func newWANDetectors(config *Config) map[string]*IPDetector {
+	detectors := make(map[string]*IPDetector, len(config.WAN))
+	for _, wan := range config.WAN {
+		detectors[wan.Name] = NewWANDetector(wan)
+	}
+	return detectors
+}
+
+// newCloudflareClients creates one Cloudflare client per credential set,
+// keyed by credential name ("" for the default [cloudflare] section). A
+// client whose credentials and rate limit are unchanged from previous keeps
+// its rate limiter, so a reload does not refill the request budget.
+func newCloudflareClients(config *Config, previous map[string]*CloudflareClient) map[string]*CloudflareClient {
+	clients := make(map[string]*CloudflareClient, len(config.Credentials)+1)
+	add := func(name string, creds CloudflareConfig) {
+		client := NewCloudflareClient(creds, config.API)
+		if old, ok := previous[name]; ok && old.sameCredentials(creds) && old.api.RateLimit == config.API.RateLimit {
+			client.limiter = old.limiter
+		}
+		clients[name] = client
+	}
+
+	if config.Cloudflare.HasCredentials() {
+		add("", config.Cloudflare)
+	}
+	for name, creds := range config.Credentials {
+		add(name, creds)
+	}
+	return clients
+}
+
+// newNotifications creates the configured notifiers, logging any problem
+func newNotifications(config *Config) *Notifications {
+	notifications, err := NewNotifications(config.Notifications)
+	if err != nil {
+		slog.Warn("Notifications disabled", "error", err)
+		return nil
 	}
+	return notifications
 }
 
-// Update performs the DNS update process
-func (u *DDNSUpdater) Update() error {
+// Update performs the DNS update process. Cancelling ctx aborts any
+// in-flight requests and stops processing further domains.
+func (u *DDNSUpdater) Update(ctx context.Context) error {
 	if err := u.validateConfig(); err != nil {
 		return err
 	}
 
 	u.logStart()
+	start := time.Now()
 
-	ipv4, ipv6, err := u.getRequiredIPs()
+	u.reconciling = u.state.ReconcileDue(time.Duration(u.config.ReconcileInterval) * time.Second)
+	if u.reconciling {
+		slog.Debug("Performing full reconcile against the Cloudflare API")
+	}
+
+	ipv4, ipv6, err := u.getRequiredIPs(ctx)
 	if err != nil {
 		return err
 	}
+	if err := u.detectWANIPs(ctx); err != nil {
+		return err
+	}
+
+	u.health.RecordIPs(ipv4, ipv6)
+
+	err = u.updateAllDomains(ctx, ipv4, ipv6)
+	if ctx.Err() == nil {
+		metrics.ObserveUpdateCycle(err == nil)
+		u.health.RecordCycle(err, time.Duration(u.config.Interval)*time.Second, u.config.Domains)
+		slog.Debug("Update cycle finished", "duration", time.Since(start))
+	}
+
+	if saveErr := u.state.Save(); saveErr != nil {
+		slog.Warn("Failed to save state", "error", saveErr)
+	}
+
+	return err
+}
+
+// Plan performs IP detection and record lookups like Update, but returns the
+// changes that would be made instead of making them. The state cache is
+// bypassed and left untouched, and no notifications are sent. Any failed IP
+// detection is returned as an error.
+func (u *DDNSUpdater) Plan(ctx context.Context) (*Plan, error) {
+	if err := u.validateConfig(); err != nil {
+		return nil, err
+	}
+
+	u.plan = &Plan{}
+	u.reconciling = true
+	defer func() {
+		u.plan = nil
+	}()
+
+	ipv4, ipv6, err := u.getRequiredIPs(ctx)
+	if err != nil {
+		return u.plan, err
+	}
+	if err := u.detectWANIPs(ctx); err != nil {
+		return u.plan, err
+	}
 
-	return u.updateAllDomains(ipv4, ipv6)
+	// A preview must not look clean when an address could not be detected,
+	// even for a family a domain with both record types can do without
+	err = u.updateAllDomains(ctx, ipv4, ipv6)
+	return u.plan, errors.Join(u.detectionErrs["A"], u.detectionErrs["AAAA"], err)
 }
 
 // validateConfig validates the configuration
@@ -53,28 +200,29 @@ func (u *DDNSUpdater) validateConfig() error {
 
 // logStart logs the start of the update process
 func (u *DDNSUpdater) logStart() {
-	if u.verbose {
-		log.Println("Starting DNS update process...")
-	}
+	slog.Debug("Starting DNS update process")
 }
 
 // getRequiredIPs determines which IP addresses are needed and fetches them
-func (u *DDNSUpdater) getRequiredIPs() (ipv4, ipv6 string, err error) {
+func (u *DDNSUpdater) getRequiredIPs(ctx context.Context) (ipv4, ipv6 string, err error) {
+	u.detectionErrs = make(map[string]error)
 	needsIPv4 := u.needsIPv4()
 	needsIPv6 := u.needsIPv6()
 
 	if needsIPv4 {
-		ipv4, err = u.getIPv4WithLogging()
+		ipv4, err = u.getIPv4WithLogging(ctx)
 		if err != nil {
 			return "", "", err
 		}
+		metrics.SetCurrentIP("ipv4", ipv4)
 	}
 
 	if needsIPv6 {
-		ipv6, err = u.getIPv6WithLogging()
+		ipv6, err = u.getIPv6WithLogging(ctx)
 		if err != nil {
 			return ipv4, "", err
 		}
+		metrics.SetCurrentIP("ipv6", ipv6)
 	}
 
 	return ipv4, ipv6, nil
@@ -82,149 +230,446 @@ func (u *DDNSUpdater) getRequiredIPs() (ipv4, ipv6 string, err error) {
 
 // needsIPv4 checks if any domain needs IPv4 updates
 func (u *DDNSUpdater) needsIPv4() bool {
-	for _, domain := range u.config.Domains {
-		if domain.ShouldUpdateA() {
-			return true
-		}
-	}
-	return false
+	return u.config.UsesDetection("A")
 }
 
 // needsIPv6 checks if any domain needs IPv6 updates
 func (u *DDNSUpdater) needsIPv6() bool {
-	for _, domain := range u.config.Domains {
-		if domain.ShouldUpdateAAAA() {
-			return true
-		}
-	}
-	return false
+	return u.config.UsesDetection("AAAA")
 }
 
 // getIPv4WithLogging gets IPv4 address with appropriate logging
-func (u *DDNSUpdater) getIPv4WithLogging() (string, error) {
-	ipv4, err := u.ipDetector.GetIPv4()
+func (u *DDNSUpdater) getIPv4WithLogging(ctx context.Context) (string, error) {
+	ipv4, err := u.ipDetector.GetIPv4(ctx)
 	if err != nil {
-		log.Printf("Warning: Failed to get IPv4 address: %v", err)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		slog.Warn("Failed to get IPv4 address", "error", err)
+		u.detectionErrs["A"] = err
 		return "", nil // Return empty string but no error to continue processing
 	}
-	if u.verbose {
-		log.Printf("Current IPv4 address: %s", ipv4)
-	}
+	slog.Debug("Detected current IP address", "record_type", "A", "new_ip", ipv4)
 	return ipv4, nil
 }
 
 // getIPv6WithLogging gets IPv6 address with appropriate logging
-func (u *DDNSUpdater) getIPv6WithLogging() (string, error) {
-	ipv6, err := u.ipDetector.GetIPv6()
+func (u *DDNSUpdater) getIPv6WithLogging(ctx context.Context) (string, error) {
+	ipv6, err := u.ipDetector.GetIPv6(ctx)
 	if err != nil {
-		log.Printf("Warning: Failed to get IPv6 address: %v", err)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		slog.Warn("Failed to get IPv6 address", "error", err)
+		u.detectionErrs["AAAA"] = err
 		return "", nil // Return empty string but no error to continue processing
 	}
-	if u.verbose {
-		log.Printf("Current IPv6 address: %s", ipv6)
-	}
+	slog.Debug("Detected current IP address", "record_type", "AAAA", "new_ip", ipv6)
 	return ipv6, nil
 }
 
+// detectWANIPs detects the addresses of the WAN sources used by any domain.
+// A source whose detection fails is left out until it is healthy again.
+func (u *DDNSUpdater) detectWANIPs(ctx context.Context) error {
+	u.wanIPs = make(map[string]string)
+	for _, wan := range u.config.WAN {
+		detector := u.wanDetectors[wan.Name]
+
+		if len(wan.IPv4) > 0 && u.config.UsesWAN(wan.Name, "A") {
+			ip, err := u.detectWANIP(ctx, wan.Name, "A", detector.GetIPv4)
+			if err != nil {
+				return err
+			}
+			u.wanIPs[wan.Name+"/A"] = ip
+		}
+
+		if len(wan.IPv6) > 0 && u.config.UsesWAN(wan.Name, "AAAA") {
+			ip, err := u.detectWANIP(ctx, wan.Name, "AAAA", detector.GetIPv6)
+			if err != nil {
+				return err
+			}
+			u.wanIPs[wan.Name+"/AAAA"] = ip
+		}
+	}
+	return nil
+}
+
+// detectWANIP detects one address of a WAN source with appropriate logging
+func (u *DDNSUpdater) detectWANIP(ctx context.Context, wan, recordType string, detect func(context.Context) (string, error)) (string, error) {
+	ip, err := detect(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		slog.Warn("Failed to get WAN address, leaving it out", "wan", wan, "record_type", recordType, "error", err)
+		return "", nil
+	}
+	slog.Debug("Detected current IP address", "wan", wan, "record_type", recordType, "new_ip", ip)
+	return ip, nil
+}
+
 // updateAllDomains updates all configured domains
-func (u *DDNSUpdater) updateAllDomains(ipv4, ipv6 string) error {
+func (u *DDNSUpdater) updateAllDomains(ctx context.Context, ipv4, ipv6 string) error {
+	failed := 0
 	for _, domain := range u.config.Domains {
-		if u.verbose {
-			log.Printf("Processing domain: %s", domain.Name)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		if err := u.updateDomain(domain, ipv4, ipv6); err != nil {
-			log.Printf("Failed to update domain %s: %v", domain.Name, err)
-			continue
+		slog.Debug("Processing domain", "domain", domain.Name)
+		start := time.Now()
+
+		err := u.updateDomain(ctx, domain, ipv4, ipv6)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if u.plan == nil {
+			u.health.RecordDomain(domain.Name, err)
+			u.trackFailures(ctx, domain.Name, err)
 		}
 
-		if u.verbose {
-			log.Printf("Successfully processed domain: %s", domain.Name)
+		if err != nil {
+			attrs := []any{"domain", domain.Name, "duration", time.Since(start), "error", err}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				attrs = append(attrs, "status", apiErr.StatusCode, "cf_ray", apiErr.RayID)
+			}
+			slog.Error("Failed to update domain", attrs...)
+			u.state.ForgetZone(domain.Name)
+			failed++
+			continue
 		}
+
+		slog.Debug("Successfully processed domain", "domain", domain.Name, "duration", time.Since(start))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d domain(s) failed to update", failed, len(u.config.Domains))
+	}
+
+	// Only count the reconcile as done once every domain was checked
+	if u.reconciling && u.plan == nil {
+		u.state.MarkReconciled()
 	}
 	return nil
 }
 
+// trackFailures counts consecutive failures of a domain and notifies once
+// when it reaches the failure threshold and again when it recovers
+func (u *DDNSUpdater) trackFailures(ctx context.Context, domainName string, err error) {
+	threshold := u.config.Notifications.FailureThreshold
+	previous := u.failures[domainName]
+
+	if err == nil {
+		delete(u.failures, domainName)
+		if previous >= threshold {
+			u.notifier.Notify(ctx, Event{
+				Type:     EventDomainRecovered,
+				Domain:   domainName,
+				Failures: previous,
+			})
+		}
+		return
+	}
+
+	u.failures[domainName] = previous + 1
+	if previous+1 == threshold {
+		u.notifier.Notify(ctx, Event{
+			Type:     EventDomainFailed,
+			Domain:   domainName,
+			Error:    err.Error(),
+			Failures: previous + 1,
+		})
+	}
+}
+
 // updateDomain updates DNS records for a specific domain
-func (u *DDNSUpdater) updateDomain(domain DomainConfig, ipv4, ipv6 string) error {
-	// Get zone ID
-	if u.verbose {
-		log.Printf("Getting zone ID for domain: %s", extractRootDomain(domain.Name))
+func (u *DDNSUpdater) updateDomain(ctx context.Context, domain DomainConfig, ipv4, ipv6 string) error {
+	cf := u.cfClients[domain.Credentials]
+	if cf == nil {
+		return fmt.Errorf("no Cloudflare credentials available")
 	}
-	zoneID, err := u.cfClient.GetZoneID(extractRootDomain(domain.Name))
+
+	// Get zone ID
+	zoneID, err := u.resolveZoneID(ctx, cf, domain)
 	if err != nil {
 		return fmt.Errorf("failed to get zone ID: %w", err)
 	}
-	if u.verbose {
-		log.Printf("Zone ID found: %s", zoneID)
+
+	if domain.UsesWAN() {
+		return u.updateWANRecords(ctx, cf, zoneID, domain)
 	}
 
+	updateA := domain.ShouldUpdateA() && ipv4 != ""
+	updateAAAA := domain.ShouldUpdateAAAA() && ipv6 != ""
+
 	// Update A record if needed
-	if domain.ShouldUpdateA() && ipv4 != "" {
-		if u.verbose {
-			log.Printf("Checking A record for %s (current IP: %s)", domain.Name, ipv4)
-		}
-		if err := u.updateRecord(zoneID, domain, "A", ipv4); err != nil {
+	if updateA {
+		err := u.updateRecord(ctx, cf, zoneID, domain, "A", ipv4)
+		metrics.ObserveRecord(domain.Name, "A", err == nil)
+		if err != nil {
 			return fmt.Errorf("failed to update A record: %w", err)
 		}
+	} else if domain.ShouldUpdateA() && u.plan != nil {
+		if err := u.planUndetected(ctx, cf, zoneID, domain, "A"); err != nil {
+			return err
+		}
 	}
 
 	// Update AAAA record if needed
-	if domain.ShouldUpdateAAAA() && ipv6 != "" {
-		if u.verbose {
-			log.Printf("Checking AAAA record for %s (current IPv6: %s)", domain.Name, ipv6)
-		}
-		if err := u.updateRecord(zoneID, domain, "AAAA", ipv6); err != nil {
+	if updateAAAA {
+		err := u.updateRecord(ctx, cf, zoneID, domain, "AAAA", u.hostIPv6(domain, ipv6))
+		metrics.ObserveRecord(domain.Name, "AAAA", err == nil)
+		if err != nil {
 			return fmt.Errorf("failed to update AAAA record: %w", err)
 		}
+	} else if domain.ShouldUpdateAAAA() && u.plan != nil {
+		if err := u.planUndetected(ctx, cf, zoneID, domain, "AAAA"); err != nil {
+			return err
+		}
 	}
 
+	// A domain with both record types tolerates one family being
+	// unavailable, e.g. on a host without IPv6, but not both
+	if !updateA && !updateAAAA {
+		return u.detectionError(domain)
+	}
 	return nil
 }
 
+// planUndetected adds the records of a type the domain has no detected
+// address for to the plan, so they show up with an unknown status
+func (u *DDNSUpdater) planUndetected(ctx context.Context, cf *CloudflareClient, zoneID string, domain DomainConfig, recordType string) error {
+	existingRecords, err := u.getExistingRecords(ctx, cf, zoneID, domain.Name, recordType)
+	if err != nil {
+		return err
+	}
+
+	desired := u.createNewRecord(domain, recordType, "")
+	if len(existingRecords) == 0 {
+		u.plan.add(PlanUnknown, zoneID, nil, desired)
+	}
+	for _, record := range existingRecords {
+		u.plan.add(PlanUnknown, zoneID, &record, desired)
+	}
+	return nil
+}
+
+// detectionError explains why no address is available for a domain
+func (u *DDNSUpdater) detectionError(domain DomainConfig) error {
+	var errs []error
+	if domain.ShouldUpdateA() {
+		errs = append(errs, u.detectionErrs["A"])
+	}
+	if domain.ShouldUpdateAAAA() {
+		errs = append(errs, u.detectionErrs["AAAA"])
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("no IP address detected: %w", err)
+	}
+	return fmt.Errorf("no IP address detected")
+}
+
 // updateRecord updates a specific DNS record
-func (u *DDNSUpdater) updateRecord(zoneID string, domain DomainConfig, recordType, content string) error {
-	u.logRecordCheck(recordType, domain.Name, content)
+func (u *DDNSUpdater) updateRecord(ctx context.Context, cf *CloudflareClient, zoneID string, domain DomainConfig, recordType, content string) error {
+	u.logRecordCheck(zoneID, recordType, domain.Name, content)
+
+	if debugEnabled() {
+		u.checkCurrentDNSResolution(ctx, domain.Name, recordType)
+	}
 
-	if u.verbose {
-		u.checkCurrentDNSResolution(domain.Name, recordType)
+	newRecord := u.createNewRecord(domain, recordType, content)
+
+	if u.isCachedUpToDate(zoneID, newRecord) {
+		return nil
 	}
 
-	existingRecords, err := u.getExistingRecords(zoneID, domain.Name, recordType)
+	existingRecords, err := u.getExistingRecords(ctx, cf, zoneID, domain.Name, recordType)
 	if err != nil {
+		u.state.ForgetRecord(domain.Name, recordType)
 		return err
 	}
 
-	newRecord := u.createNewRecord(domain, recordType, content)
+	if len(existingRecords) > 1 {
+		return u.handleDuplicateRecords(ctx, cf, zoneID, domain, existingRecords, newRecord)
+	}
+	if len(existingRecords) == 1 {
+		return u.handleExistingRecord(ctx, cf, zoneID, existingRecords[0], newRecord, recordType, domain.Name, content)
+	}
+
+	return u.createRecord(ctx, cf, zoneID, newRecord, recordType, domain.Name, content)
+}
+
+// updateWANRecords updates the records of a domain that publishes one
+// address per WAN source
+func (u *DDNSUpdater) updateWANRecords(ctx context.Context, cf *CloudflareClient, zoneID string, domain DomainConfig) error {
+	for _, recordType := range []string{"A", "AAAA"} {
+		if (recordType == "A" && !domain.ShouldUpdateA()) || (recordType == "AAAA" && !domain.ShouldUpdateAAAA()) {
+			continue
+		}
+
+		contents, sources := u.wanContents(domain, recordType)
+		if sources == 0 {
+			continue
+		}
+		if len(contents) == 0 {
+			// Never remove every record just because detection failed
+			slog.Warn("No healthy WAN address, leaving records unchanged", "domain", domain.Name, "record_type", recordType)
+			continue
+		}
 
-	if len(existingRecords) > 0 {
-		return u.handleExistingRecord(zoneID, existingRecords[0], newRecord, recordType, domain.Name, content)
+		err := u.updateRecordSet(ctx, cf, zoneID, domain, recordType, contents)
+		metrics.ObserveRecord(domain.Name, recordType, err == nil)
+		if err != nil {
+			return fmt.Errorf("failed to update %s records: %w", recordType, err)
+		}
 	}
+	return nil
+}
 
-	return u.createRecord(zoneID, newRecord, recordType, domain.Name, content)
+// wanContents returns the distinct addresses detected for the domain's
+// healthy WAN sources, and how many of its sources detect the record type
+func (u *DDNSUpdater) wanContents(domain DomainConfig, recordType string) (contents []string, sources int) {
+	for _, wan := range domain.WAN {
+		ip, ok := u.wanIPs[wan+"/"+recordType]
+		if !ok {
+			continue
+		}
+		sources++
+		if ip != "" && recordType == "AAAA" {
+			ip = u.hostIPv6(domain, ip)
+		}
+		if ip != "" && !slices.Contains(contents, ip) {
+			contents = append(contents, ip)
+		}
+	}
+	return contents, sources
 }
 
-// logRecordCheck logs the initial record check
-func (u *DDNSUpdater) logRecordCheck(recordType, domainName, content string) {
-	if u.verbose {
-		log.Printf("Checking %s record for %s (target IP: %s)", recordType, domainName, content)
+// hostIPv6 returns the AAAA content for a domain, combining the detected
+// prefix with the domain's interface identifier when one is configured
+func (u *DDNSUpdater) hostIPv6(domain DomainConfig, ipv6 string) string {
+	content := domain.hostIPv6(ipv6)
+	if content != ipv6 {
+		slog.Debug("Derived host address from detected prefix", "domain", domain.Name, "record_type", "AAAA",
+			"prefix_length", domain.IPv6PrefixLength, "detected", ipv6, "new_ip", content)
 	}
+	return content
 }
 
-// getExistingRecords retrieves existing DNS records from Cloudflare
-func (u *DDNSUpdater) getExistingRecords(zoneID, domainName, recordType string) ([]DNSRecord, error) {
-	if u.verbose {
-		log.Printf("Retrieving existing %s records for %s from Cloudflare API...", recordType, domainName)
+// updateRecordSet makes the records of a type for the name match contents
+// exactly, with one record per address. Records with an address that is no
+// longer wanted are reused for new addresses first and deleted after.
+func (u *DDNSUpdater) updateRecordSet(ctx context.Context, cf *CloudflareClient, zoneID string, domain DomainConfig, recordType string, contents []string) error {
+	desired := make([]DNSRecord, len(contents))
+	for i, content := range contents {
+		u.logRecordCheck(zoneID, recordType, domain.Name, content)
+		desired[i] = u.createNewRecord(domain, recordType, content)
+	}
+
+	if debugEnabled() {
+		u.checkCurrentDNSResolution(ctx, domain.Name, recordType)
+	}
+
+	if u.isCachedSetUpToDate(zoneID, desired) {
+		return nil
 	}
 
-	existingRecords, err := u.cfClient.GetDNSRecords(zoneID, domainName, recordType)
+	existingRecords, err := u.getExistingRecords(ctx, cf, zoneID, domain.Name, recordType)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get existing records: %w", err)
+		u.state.ForgetRecord(domain.Name, recordType)
+		return err
+	}
+
+	current := make(map[string]DNSRecord, len(existingRecords))
+	var stale []DNSRecord
+	for _, record := range existingRecords {
+		if _, seen := current[record.Content]; !seen && slices.Contains(contents, record.Content) {
+			current[record.Content] = record
+			continue
+		}
+		stale = append(stale, record)
+	}
+
+	for _, newRecord := range desired {
+		content := newRecord.Content
+		if record, ok := current[content]; ok {
+			err = u.handleExistingRecord(ctx, cf, zoneID, record, newRecord, recordType, domain.Name, content)
+		} else if len(stale) > 0 {
+			err = u.handleExistingRecord(ctx, cf, zoneID, stale[0], newRecord, recordType, domain.Name, content)
+			stale = stale[1:]
+		} else {
+			err = u.createRecord(ctx, cf, zoneID, newRecord, recordType, domain.Name, content)
+		}
+		if err != nil {
+			u.state.ForgetRecord(domain.Name, recordType)
+			return err
+		}
+	}
+
+	for _, record := range stale {
+		if err := u.deleteRecord(ctx, cf, zoneID, record); err != nil {
+			u.state.ForgetRecord(domain.Name, recordType)
+			return err
+		}
 	}
 
-	if u.verbose {
-		log.Printf("Found %d existing %s record(s) for %s", len(existingRecords), recordType, domainName)
+	if u.plan == nil {
+		u.state.SetRecordSet(zoneID, desired)
 	}
+	return nil
+}
+
+// isCachedUpToDate returns true if the state cache shows the record already
+// has the desired settings, in which case no API call is needed
+func (u *DDNSUpdater) isCachedUpToDate(zoneID string, newRecord DNSRecord) bool {
+	if u.reconciling {
+		return false
+	}
+
+	cached, ok := u.state.Record(newRecord.Name, newRecord.Type)
+	if !ok || !cached.Matches(zoneID, newRecord) {
+		return false
+	}
+
+	slog.Debug("Record unchanged since last update, skipping API calls",
+		"domain", newRecord.Name, "record_type", newRecord.Type, "zone_id", zoneID, "new_ip", cached.Content)
+	return true
+}
+
+// isCachedSetUpToDate returns true if the state cache shows the records
+// published from several WAN sources already match the desired set
+func (u *DDNSUpdater) isCachedSetUpToDate(zoneID string, desired []DNSRecord) bool {
+	if u.reconciling {
+		return false
+	}
+
+	first := desired[0]
+	cached, ok := u.state.Record(first.Name, first.Type)
+	if !ok || !cached.MatchesSet(zoneID, desired) {
+		return false
+	}
+
+	slog.Debug("Records unchanged since last update, skipping API calls",
+		"domain", first.Name, "record_type", first.Type, "zone_id", zoneID, "addresses", cached.Contents)
+	return true
+}
+
+// logRecordCheck logs the initial record check
+func (u *DDNSUpdater) logRecordCheck(zoneID, recordType, domainName, content string) {
+	slog.Debug("Checking record", "domain", domainName, "record_type", recordType, "zone_id", zoneID, "new_ip", content)
+}
+
+// getExistingRecords retrieves existing DNS records from Cloudflare
+func (u *DDNSUpdater) getExistingRecords(ctx context.Context, cf *CloudflareClient, zoneID, domainName, recordType string) ([]DNSRecord, error) {
+	start := time.Now()
+	existingRecords, err := cf.GetDNSRecords(ctx, zoneID, domainName, recordType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing records: %w", err)
+	}
+
+	slog.Debug("Retrieved existing records from Cloudflare", "domain", domainName, "record_type", recordType,
+		"zone_id", zoneID, "count", len(existingRecords), "duration", time.Since(start))
 
 	return existingRecords, nil
 }
@@ -241,19 +686,100 @@ func (u *DDNSUpdater) createNewRecord(domain DomainConfig, recordType, content s
 }
 
 // handleExistingRecord handles updating an existing DNS record
-func (u *DDNSUpdater) handleExistingRecord(zoneID string, existingRecord DNSRecord, newRecord DNSRecord, recordType, domainName, content string) error {
-	if u.verbose {
-		log.Printf("Current %s record for %s: IP=%s, TTL=%d, Proxied=%t",
-			recordType, domainName, existingRecord.Content, existingRecord.TTL, existingRecord.Proxied)
-	}
+func (u *DDNSUpdater) handleExistingRecord(ctx context.Context, cf *CloudflareClient, zoneID string, existingRecord DNSRecord, newRecord DNSRecord, recordType, domainName, content string) error {
+	slog.Debug("Found existing record", "domain", domainName, "record_type", recordType, "zone_id", zoneID,
+		"old_ip", existingRecord.Content, "ttl", existingRecord.TTL, "proxied", existingRecord.Proxied)
 
 	if u.recordNeedsUpdate(existingRecord, newRecord) {
-		return u.updateExistingRecord(zoneID, existingRecord, newRecord, recordType, domainName, content)
+		if u.plan != nil {
+			u.plan.add(PlanUpdate, zoneID, &existingRecord, newRecord)
+			return nil
+		}
+		return u.updateExistingRecord(ctx, cf, zoneID, existingRecord, newRecord, recordType, domainName, content)
 	}
 
-	if u.verbose {
-		log.Printf("%s record for %s is already up to date - no API call needed", recordType, domainName)
+	if u.plan != nil {
+		u.plan.add(PlanUnchanged, zoneID, &existingRecord, newRecord)
+		return nil
 	}
+
+	slog.Debug("Record is already up to date, no API call needed",
+		"domain", domainName, "record_type", recordType, "zone_id", zoneID, "new_ip", content)
+	newRecord.ID = existingRecord.ID
+	u.state.SetRecord(zoneID, newRecord)
+	return nil
+}
+
+// handleDuplicateRecords applies the domain's duplicates policy when several
+// records of the same type exist for the name
+func (u *DDNSUpdater) handleDuplicateRecords(ctx context.Context, cf *CloudflareClient, zoneID string, domain DomainConfig, existingRecords []DNSRecord, newRecord DNSRecord) error {
+	recordType, content := newRecord.Type, newRecord.Content
+	slog.Info("Found duplicate records", "domain", domain.Name, "record_type", recordType, "zone_id", zoneID,
+		"count", len(existingRecords), "policy", domain.Duplicates)
+
+	switch domain.Duplicates {
+	case DuplicatesError:
+		u.state.ForgetRecord(domain.Name, recordType)
+		return fmt.Errorf("%d %s records exist for %s and duplicates = %q", len(existingRecords), recordType, domain.Name, DuplicatesError)
+
+	case DuplicatesDeleteExtras:
+		keep := preferredRecord(existingRecords, content)
+		for i, record := range existingRecords {
+			if i == keep {
+				continue
+			}
+			if err := u.deleteRecord(ctx, cf, zoneID, record); err != nil {
+				u.state.ForgetRecord(domain.Name, recordType)
+				return err
+			}
+		}
+		return u.handleExistingRecord(ctx, cf, zoneID, existingRecords[keep], newRecord, recordType, domain.Name, content)
+
+	default:
+		for _, record := range existingRecords {
+			if err := u.handleExistingRecord(ctx, cf, zoneID, record, newRecord, recordType, domain.Name, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// preferredRecord returns the index of the record to keep among duplicates:
+// the first one that already has the desired content, or else the first one
+func preferredRecord(records []DNSRecord, content string) int {
+	for i, record := range records {
+		if record.Content == content {
+			return i
+		}
+	}
+	return 0
+}
+
+// deleteRecord deletes a duplicate or no longer wanted DNS record
+func (u *DDNSUpdater) deleteRecord(ctx context.Context, cf *CloudflareClient, zoneID string, record DNSRecord) error {
+	if u.plan != nil {
+		u.plan.add(PlanDelete, zoneID, &record, DNSRecord{Type: record.Type, Name: record.Name})
+		return nil
+	}
+
+	logger := slog.With("domain", record.Name, "record_type", record.Type, "zone_id", zoneID, "old_ip", record.Content)
+
+	logger.Info("Deleting record")
+	start := time.Now()
+	if err := cf.DeleteDNSRecord(ctx, zoneID, record.ID); err != nil {
+		return fmt.Errorf("failed to delete record: %w", err)
+	}
+	metrics.ObserveRecordChange(record.Name, record.Type, "deleted")
+	u.notifier.Notify(ctx, Event{
+		Type:       EventRecordChanged,
+		Domain:     record.Name,
+		RecordType: record.Type,
+		Action:     "deleted",
+		OldContent: record.Content,
+	})
+
+	logger.Info("Successfully deleted record", "duration", time.Since(start))
 	return nil
 }
 
@@ -265,60 +791,77 @@ func (u *DDNSUpdater) recordNeedsUpdate(existing, new DNSRecord) bool {
 }
 
 // updateExistingRecord updates an existing DNS record
-func (u *DDNSUpdater) updateExistingRecord(zoneID string, existingRecord DNSRecord, newRecord DNSRecord, recordType, domainName, content string) error {
-	if u.verbose {
-		log.Printf("DNS record needs update: Current IP (%s) != Target IP (%s) OR TTL/Proxy settings differ",
-			existingRecord.Content, content)
-	}
+func (u *DDNSUpdater) updateExistingRecord(ctx context.Context, cf *CloudflareClient, zoneID string, existingRecord DNSRecord, newRecord DNSRecord, recordType, domainName, content string) error {
+	logger := slog.With("domain", domainName, "record_type", recordType, "zone_id", zoneID,
+		"old_ip", existingRecord.Content, "new_ip", content)
 
-	log.Printf("Updating %s record for %s: %s to %s", recordType, domainName, existingRecord.Content, content)
-	_, err := u.cfClient.UpdateDNSRecord(zoneID, existingRecord.ID, newRecord)
+	logger.Info("Updating record")
+	start := time.Now()
+	updatedRecord, err := cf.UpdateDNSRecord(ctx, zoneID, existingRecord.ID, newRecord)
 	if err != nil {
+		u.state.ForgetRecord(domainName, recordType)
 		return fmt.Errorf("failed to update existing record: %w", err)
 	}
-
-	log.Printf("Successfully updated %s record for %s", recordType, domainName)
+	newRecord.ID = updatedRecord.ID
+	u.state.SetRecord(zoneID, newRecord)
+	metrics.ObserveRecordChange(domainName, recordType, "updated")
+	u.notifier.Notify(ctx, Event{
+		Type:       EventRecordChanged,
+		Domain:     domainName,
+		RecordType: recordType,
+		Action:     "updated",
+		OldContent: existingRecord.Content,
+		NewContent: content,
+	})
+
+	logger.Info("Successfully updated record", "duration", time.Since(start))
 	return nil
 }
 
 // createRecord creates a new DNS record
-func (u *DDNSUpdater) createRecord(zoneID string, newRecord DNSRecord, recordType, domainName, content string) error {
-	if u.verbose {
-		log.Printf("No existing %s record found for %s, creating new record...", recordType, domainName)
+func (u *DDNSUpdater) createRecord(ctx context.Context, cf *CloudflareClient, zoneID string, newRecord DNSRecord, recordType, domainName, content string) error {
+	if u.plan != nil {
+		u.plan.add(PlanCreate, zoneID, nil, newRecord)
+		return nil
 	}
 
-	log.Printf("Creating %s record for %s with IP %s", recordType, domainName, content)
-	_, err := u.cfClient.CreateDNSRecord(zoneID, newRecord)
+	logger := slog.With("domain", domainName, "record_type", recordType, "zone_id", zoneID, "new_ip", content)
+
+	logger.Info("Creating record")
+	start := time.Now()
+	createdRecord, err := cf.CreateDNSRecord(ctx, zoneID, newRecord)
 	if err != nil {
+		u.state.ForgetRecord(domainName, recordType)
 		return fmt.Errorf("failed to create new record: %w", err)
 	}
-
-	log.Printf("Successfully created %s record for %s", recordType, domainName)
+	newRecord.ID = createdRecord.ID
+	u.state.SetRecord(zoneID, newRecord)
+	metrics.ObserveRecordChange(domainName, recordType, "created")
+	u.notifier.Notify(ctx, Event{
+		Type:       EventRecordChanged,
+		Domain:     domainName,
+		RecordType: recordType,
+		Action:     "created",
+		NewContent: content,
+	})
+
+	logger.Info("Successfully created record", "duration", time.Since(start))
 	return nil
 }
 
 // checkCurrentDNSResolution checks what the domain currently resolves to via DNS
-func (u *DDNSUpdater) checkCurrentDNSResolution(domain, recordType string) {
-	log.Printf("Performing DNS lookup to check current resolution for %s (%s record)...", domain, recordType)
+func (u *DDNSUpdater) checkCurrentDNSResolution(ctx context.Context, domain, recordType string) {
+	logger := slog.With("domain", domain, "record_type", recordType)
+	logger.Debug("Performing DNS lookup to check current resolution")
 
 	// Set a timeout for DNS resolution
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: 5 * time.Second,
-			}
-			return d.DialContext(ctx, network, address)
-		},
-	}
-
-	ctx := context.Background()
+	resolver := newDNSResolver("", "")
 
 	if recordType == "A" {
 		// Look up IPv4 addresses
 		addrs, err := resolver.LookupIPAddr(ctx, domain)
 		if err != nil {
-			log.Printf("DNS lookup failed for %s (A record): %v", domain, err)
+			logger.Debug("DNS lookup failed", "error", err)
 			return
 		}
 
@@ -330,15 +873,15 @@ func (u *DDNSUpdater) checkCurrentDNSResolution(domain, recordType string) {
 		}
 
 		if len(ipv4Addrs) > 0 {
-			log.Printf("Current DNS resolution for %s (A): %v", domain, ipv4Addrs)
+			logger.Debug("Current DNS resolution", "addresses", ipv4Addrs)
 		} else {
-			log.Printf("No A records found in DNS for %s", domain)
+			logger.Debug("No records found in DNS")
 		}
 	} else if recordType == "AAAA" {
 		// Look up IPv6 addresses
 		addrs, err := resolver.LookupIPAddr(ctx, domain)
 		if err != nil {
-			log.Printf("DNS lookup failed for %s (AAAA record): %v", domain, err)
+			logger.Debug("DNS lookup failed", "error", err)
 			return
 		}
 
@@ -350,20 +893,74 @@ func (u *DDNSUpdater) checkCurrentDNSResolution(domain, recordType string) {
 		}
 
 		if len(ipv6Addrs) > 0 {
-			log.Printf("Current DNS resolution for %s (AAAA): %v", domain, ipv6Addrs)
+			logger.Debug("Current DNS resolution", "addresses", ipv6Addrs)
 		} else {
-			log.Printf("No AAAA records found in DNS for %s", domain)
+			logger.Debug("No records found in DNS")
 		}
 	}
 }
 
-// extractRootDomain extracts the root domain from a subdomain
-func extractRootDomain(domain string) string {
+// resolveZoneID finds the zone containing a domain, caching the result.
+// An explicit zone_id on the domain skips the lookup. The zone_id of its
+// credentials is only used if the domain is inside that zone.
+func (u *DDNSUpdater) resolveZoneID(ctx context.Context, cf *CloudflareClient, domain DomainConfig) (string, error) {
+	if domain.ZoneID != "" {
+		return domain.ZoneID, nil
+	}
+
+	domainName := domain.Name
+	if zoneID, ok := u.state.Zone(domainName); ok {
+		slog.Debug("Using cached zone ID", "domain", domainName, "zone_id", zoneID)
+		return zoneID, nil
+	}
+
+	if zoneID := u.config.CredentialsFor(domain).ZoneID; zoneID != "" {
+		zone, err := cf.GetZone(ctx, zoneID)
+		if err != nil {
+			return "", err
+		}
+		if inZone(domainName, zone.Name) {
+			slog.Debug("Using zone ID of the credentials", "domain", domainName, "zone", zone.Name, "zone_id", zone.ID)
+			u.state.SetZone(domainName, zone.ID)
+			return zone.ID, nil
+		}
+		slog.Debug("Domain is outside the zone of the credentials, looking up its zone",
+			"domain", domainName, "zone", zone.Name, "zone_id", zone.ID)
+	}
+
+	for _, candidate := range zoneCandidates(domainName) {
+		slog.Debug("Getting zone ID for candidate zone", "domain", domainName, "zone", candidate)
+
+		zoneID, err := cf.GetZoneID(ctx, candidate)
+		if errors.Is(err, ErrZoneNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		slog.Debug("Zone ID found", "domain", domainName, "zone", candidate, "zone_id", zoneID)
+		u.state.SetZone(domainName, zoneID)
+		return zoneID, nil
+	}
+
+	return "", fmt.Errorf("%w for domain %s", ErrZoneNotFound, domainName)
+}
+
+// zoneCandidates returns the possible zone names for a domain, from the most
+// specific to the least specific. The bare top-level label is never a
+// candidate, so "home.example.co.uk" yields "home.example.co.uk",
+// "example.co.uk" and "co.uk".
+func zoneCandidates(domain string) []string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
 	parts := strings.Split(domain, ".")
 	if len(parts) <= 2 {
-		return domain
+		return []string{domain}
 	}
 
-	// Return the last two parts (root domain)
-	return strings.Join(parts[len(parts)-2:], ".")
+	candidates := make([]string, 0, len(parts)-1)
+	for i := 0; i < len(parts)-1; i++ {
+		candidates = append(candidates, strings.Join(parts[i:], "."))
+	}
+	return candidates
 }
@@ -0,0 +1,621 @@
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDNSAPI is an in-memory stand-in for the zone and DNS records
+// endpoints of the Cloudflare API, serving the records of a single zone
+type fakeDNSAPI struct {
+	mu      sync.Mutex
+	zoneID  string
+	zones   []Zone
+	records []DNSRecord
+	nextID  int
+
+	// pageSize overrides the per_page of list requests when set
+	pageSize int
+
+	// noResultInfo leaves the paging metadata out of list responses
+	noResultInfo bool
+
+	// extraPage reports one more page than there is, which is then empty
+	extraPage bool
+
+	// lists holds the query of every list request
+	lists []url.Values
+
+	// changes lists the modifying requests as "<method> <record id>"
+	changes []string
+}
+
+// startFakeDNSAPI serves records for zoneID and returns a client talking to it
+func startFakeDNSAPI(t *testing.T, zoneID string, records []DNSRecord) (*fakeDNSAPI, *CloudflareClient) {
+	t.Helper()
+	api := &fakeDNSAPI{zoneID: zoneID, records: slices.Clone(records)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	base, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := APIConfig{MaxAttempts: 1}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	cf := NewCloudflareClient(CloudflareConfig{APIToken: "test"}, config)
+	cf.client.Transport = rewriteHost{base: base}
+	return api, cf
+}
+
+// rewriteHost sends every request to the fake server instead of Cloudflare
+type rewriteHost struct {
+	base *url.URL
+}
+
+func (r rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = r.base.Scheme
+	req.URL.Host = r.base.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (f *fakeDNSAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := "/client/v4/zones/" + f.zoneID + "/dns_records"
+	id, hasID := strings.CutPrefix(r.URL.Path, prefix+"/")
+	query := r.URL.Query()
+	switch {
+	case r.URL.Path == "/client/v4/zones" && r.Method == http.MethodGet:
+		f.lists = append(f.lists, query)
+		matches := []Zone{}
+		for _, zone := range f.zones {
+			if query.Get("name") == "" || zone.Name == query.Get("name") {
+				matches = append(matches, zone)
+			}
+		}
+		page, info := paginate(matches, query, f.pageSize)
+		f.replyPage(w, page, info)
+
+	case r.URL.Path == prefix && r.Method == http.MethodGet:
+		f.lists = append(f.lists, query)
+		matches := []DNSRecord{}
+		for _, record := range f.records {
+			if (query.Get("name") == "" || record.Name == query.Get("name")) &&
+				(query.Get("type") == "" || record.Type == query.Get("type")) {
+				matches = append(matches, record)
+			}
+		}
+		page, info := paginate(matches, query, f.pageSize)
+		f.replyPage(w, page, info)
+
+	case r.URL.Path == prefix && r.Method == http.MethodPost:
+		var record DNSRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		f.nextID++
+		record.ID = fmt.Sprintf("new%d", f.nextID)
+		f.records = append(f.records, record)
+		f.changes = append(f.changes, "POST "+record.ID)
+		f.reply(w, record)
+
+	case hasID && r.Method == http.MethodPut:
+		var record DNSRecord
+		json.NewDecoder(r.Body).Decode(&record)
+		record.ID = id
+		i := f.index(id)
+		if i < 0 {
+			f.notFound(w)
+			return
+		}
+		f.records[i] = record
+		f.changes = append(f.changes, "PUT "+id)
+		f.reply(w, record)
+
+	case hasID && r.Method == http.MethodDelete:
+		i := f.index(id)
+		if i < 0 {
+			f.notFound(w)
+			return
+		}
+		f.records = slices.Delete(f.records, i, i+1)
+		f.changes = append(f.changes, "DELETE "+id)
+		f.reply(w, map[string]string{"id": id})
+
+	default:
+		f.notFound(w)
+	}
+}
+
+// index returns the position of the record with id, or -1
+func (f *fakeDNSAPI) index(id string) int {
+	return slices.IndexFunc(f.records, func(record DNSRecord) bool { return record.ID == id })
+}
+
+// reply writes a successful API response with result
+func (f *fakeDNSAPI) reply(w http.ResponseWriter, result any) {
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "errors": []any{}, "result": result})
+}
+
+// replyPage writes a page of a list response with its paging metadata
+func (f *fakeDNSAPI) replyPage(w http.ResponseWriter, items any, info ResultInfo) {
+	resp := map[string]any{"success": true, "errors": []any{}, "result": items}
+	if f.extraPage {
+		info.TotalPages++
+	}
+	if !f.noResultInfo {
+		resp["result_info"] = info
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// paginate returns the page of items a list request asks for, using
+// pageSize instead of its per_page when set
+func paginate[T any](items []T, query url.Values, pageSize int) ([]T, ResultInfo) {
+	page, _ := strconv.Atoi(query.Get("page"))
+	page = max(page, 1)
+	if pageSize == 0 {
+		pageSize, _ = strconv.Atoi(query.Get("per_page"))
+	}
+
+	start := min((page-1)*pageSize, len(items))
+	end := min(start+pageSize, len(items))
+	return items[start:end], ResultInfo{
+		Page:       page,
+		PerPage:    pageSize,
+		Count:      end - start,
+		TotalCount: len(items),
+		TotalPages: (len(items) + pageSize - 1) / pageSize,
+	}
+}
+
+// notFound writes an API error for an unknown route or record
+func (f *fakeDNSAPI) notFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": false,
+		"errors":  []map[string]any{{"code": 81044, "message": "Record does not exist."}},
+	})
+}
+
+// contents returns the content of each remaining record, keyed by ID
+func (f *fakeDNSAPI) contents() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	contents := make(map[string]string, len(f.records))
+	for _, record := range f.records {
+		contents[record.ID] = record.Content
+	}
+	return contents
+}
+
+func TestDuplicatesPolicy(t *testing.T) {
+	const (
+		zoneID = "zone1"
+		name   = "home.example.com"
+		want   = "203.0.113.1"
+	)
+	record := func(id, content string) DNSRecord {
+		return DNSRecord{ID: id, Type: "A", Name: name, Content: content, TTL: 300}
+	}
+	other := DNSRecord{ID: "other", Type: "A", Name: "www.example.com", Content: "198.51.100.99", TTL: 300}
+
+	tests := []struct {
+		name    string
+		policy  string
+		records []DNSRecord
+		wantErr bool
+		changes []string
+		remain  map[string]string
+	}{
+		{
+			name:    "update_all updates every stale record",
+			policy:  DuplicatesUpdateAll,
+			records: []DNSRecord{record("r1", "198.51.100.1"), record("r2", want), record("r3", "198.51.100.3"), other},
+			changes: []string{"PUT r1", "PUT r3"},
+			remain:  map[string]string{"r1": want, "r2": want, "r3": want, "other": "198.51.100.99"},
+		},
+		{
+			name:    "default policy is update_all",
+			policy:  "",
+			records: []DNSRecord{record("r1", "198.51.100.1"), record("r2", "198.51.100.2")},
+			changes: []string{"PUT r1", "PUT r2"},
+			remain:  map[string]string{"r1": want, "r2": want},
+		},
+		{
+			name:    "update_all leaves matching duplicates alone",
+			policy:  DuplicatesUpdateAll,
+			records: []DNSRecord{record("r1", want), record("r2", want)},
+			changes: nil,
+			remain:  map[string]string{"r1": want, "r2": want},
+		},
+		{
+			name:    "delete_extras keeps the record with the current address",
+			policy:  DuplicatesDeleteExtras,
+			records: []DNSRecord{record("r1", "198.51.100.1"), record("r2", want), record("r3", "198.51.100.3"), other},
+			changes: []string{"DELETE r1", "DELETE r3"},
+			remain:  map[string]string{"r2": want, "other": "198.51.100.99"},
+		},
+		{
+			name:    "delete_extras keeps and updates the first record when none match",
+			policy:  DuplicatesDeleteExtras,
+			records: []DNSRecord{record("r1", "198.51.100.1"), record("r2", "198.51.100.2")},
+			changes: []string{"DELETE r2", "PUT r1"},
+			remain:  map[string]string{"r1": want},
+		},
+		{
+			name:    "delete_extras keeps the first of several matching records",
+			policy:  DuplicatesDeleteExtras,
+			records: []DNSRecord{record("r1", "198.51.100.1"), record("r2", want), record("r3", want)},
+			changes: []string{"DELETE r1", "DELETE r3"},
+			remain:  map[string]string{"r2": want},
+		},
+		{
+			name:    "error changes nothing",
+			policy:  DuplicatesError,
+			records: []DNSRecord{record("r1", "198.51.100.1"), record("r2", want), other},
+			wantErr: true,
+			changes: nil,
+			remain:  map[string]string{"r1": "198.51.100.1", "r2": want, "other": "198.51.100.99"},
+		},
+		{
+			name:    "single record is updated under every policy",
+			policy:  DuplicatesError,
+			records: []DNSRecord{record("r1", "198.51.100.1")},
+			changes: []string{"PUT r1"},
+			remain:  map[string]string{"r1": want},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, cf := startFakeDNSAPI(t, zoneID, tt.records)
+			u := &DDNSUpdater{config: &Config{}, state: NewState(""), reconciling: true}
+			domain := DomainConfig{Name: name, RecordTypes: "A", TTL: 300, Duplicates: tt.policy}
+
+			err := u.updateRecord(context.Background(), cf, zoneID, domain, "A", want)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(api.changes, tt.changes) {
+				t.Errorf("changes = %v, want %v", api.changes, tt.changes)
+			}
+			if got := api.contents(); !maps.Equal(got, tt.remain) {
+				t.Errorf("records = %v, want %v", got, tt.remain)
+			}
+		})
+	}
+}
+
+func TestDuplicatesPolicyPlanMakesNoChanges(t *testing.T) {
+	records := []DNSRecord{
+		{ID: "r1", Type: "A", Name: "home.example.com", Content: "198.51.100.1", TTL: 300},
+		{ID: "r2", Type: "A", Name: "home.example.com", Content: "198.51.100.2", TTL: 300},
+	}
+	api, cf := startFakeDNSAPI(t, "zone1", records)
+	u := &DDNSUpdater{config: &Config{}, state: NewState(""), reconciling: true, plan: &Plan{}}
+	domain := DomainConfig{Name: "home.example.com", RecordTypes: "A", TTL: 300, Duplicates: DuplicatesDeleteExtras}
+
+	if err := u.updateRecord(context.Background(), cf, "zone1", domain, "A", "203.0.113.1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.changes) > 0 {
+		t.Errorf("plan made changes: %v", api.changes)
+	}
+
+	var got []string
+	for _, change := range u.plan.Changes {
+		got = append(got, change.Action+" "+change.Current.ID)
+	}
+	want := []string{PlanDelete + " r2", PlanUpdate + " r1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("planned %v, want %v", got, want)
+	}
+}
+
+func TestReloadKeepsRateLimiter(t *testing.T) {
+	config := &Config{
+		Cloudflare:  CloudflareConfig{APIToken: "token"},
+		Credentials: map[string]CloudflareConfig{"other": {APIToken: "other"}},
+		API:         APIConfig{RateLimit: 1200},
+	}
+	u := NewDDNSUpdater(config)
+	defaultLimiter, otherLimiter := u.cfClients[""].limiter, u.cfClients["other"].limiter
+
+	reloaded := *config
+	reloaded.Credentials = map[string]CloudflareConfig{"other": {APIToken: "rotated"}}
+	u.Reload(&reloaded)
+	if u.cfClients[""].limiter != defaultLimiter {
+		t.Error("unchanged credentials got a new rate limiter")
+	}
+	if u.cfClients["other"].limiter == otherLimiter {
+		t.Error("changed token kept the old rate limiter")
+	}
+
+	limited := reloaded
+	limited.API.RateLimit = 600
+	u.Reload(&limited)
+	if u.cfClients[""].limiter == defaultLimiter {
+		t.Error("changed rate limit kept the old rate limiter")
+	}
+}
+
+// staticProvider answers with a fixed address, or fails when ip is empty
+type staticProvider struct {
+	ip string
+}
+
+func (p staticProvider) Name() string {
+	return "static"
+}
+
+func (p staticProvider) GetIP(ctx context.Context, ipv6 bool) (string, error) {
+	if p.ip == "" {
+		return "", errors.New("no address")
+	}
+	return p.ip, nil
+}
+
+// newTestUpdater creates an updater for domains in zone1 of a fake DNS API
+// holding records, detecting ipv4 and ipv6 (empty to fail detection)
+func newTestUpdater(t *testing.T, domains []DomainConfig, records []DNSRecord, ipv4, ipv6 string) (*DDNSUpdater, *fakeDNSAPI) {
+	t.Helper()
+	for i := range domains {
+		domains[i].ZoneID = "zone1"
+	}
+	config := &Config{Cloudflare: CloudflareConfig{APIToken: "test"}, Domains: domains}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	api, cf := startFakeDNSAPI(t, "zone1", records)
+	u := NewDDNSUpdater(config)
+	u.cfClients[""] = cf
+	u.ipDetector = &IPDetector{
+		ipv4Providers: []IPProvider{staticProvider{ipv4}},
+		ipv6Providers: []IPProvider{staticProvider{ipv6}},
+		mode:          DetectionModeFirst,
+	}
+	return u, api
+}
+
+func TestUpdateFailsDomainsWithoutAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		domains    []DomainConfig
+		ipv4, ipv6 string
+		wantErr    bool
+		failed     []string
+		changes    []string
+	}{
+		{
+			name:    "A without IPv4",
+			domains: []DomainConfig{{Name: "a.example.com", RecordTypes: "A"}},
+			ipv6:    "2001:db8::1",
+			wantErr: true,
+			failed:  []string{"a.example.com"},
+		},
+		{
+			name:    "both without IPv6",
+			domains: []DomainConfig{{Name: "a.example.com", RecordTypes: "both"}},
+			ipv4:    "203.0.113.1",
+			changes: []string{"POST new1"},
+		},
+		{
+			name:    "both without any address",
+			domains: []DomainConfig{{Name: "a.example.com", RecordTypes: "both"}},
+			wantErr: true,
+			failed:  []string{"a.example.com"},
+		},
+		{
+			name: "AAAA without IPv6 next to a healthy A",
+			domains: []DomainConfig{
+				{Name: "a.example.com", RecordTypes: "A"},
+				{Name: "b.example.com", RecordTypes: "AAAA"},
+			},
+			ipv4:    "203.0.113.1",
+			wantErr: true,
+			failed:  []string{"b.example.com"},
+			changes: []string{"POST new1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, api := newTestUpdater(t, tt.domains, nil, tt.ipv4, tt.ipv6)
+
+			err := u.Update(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(api.changes, tt.changes) {
+				t.Errorf("changes = %v, want %v", api.changes, tt.changes)
+			}
+			for _, domain := range tt.domains {
+				failed := slices.Contains(tt.failed, domain.Name)
+				if status := u.health.domains[domain.Name]; status == nil || status.OK == failed {
+					t.Errorf("%s: health = %+v, want ok %v", domain.Name, status, !failed)
+				}
+				if got := u.failures[domain.Name]; (got == 1) != failed {
+					t.Errorf("%s: failures = %d, want failed %v", domain.Name, got, failed)
+				}
+			}
+			if _, _, ready := u.health.report(); ready == tt.wantErr {
+				t.Errorf("ready = %v, want %v", ready, !tt.wantErr)
+			}
+			if reconciled := !u.state.LastReconcile.IsZero(); reconciled == tt.wantErr {
+				t.Errorf("reconciled = %v, want %v", reconciled, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlanFailsWhenDetectionFails(t *testing.T) {
+	records := []DNSRecord{
+		{ID: "r1", Type: "A", Name: "a.example.com", Content: "198.51.100.1", TTL: 300},
+		{ID: "r2", Type: "AAAA", Name: "a.example.com", Content: "2001:db8::2", TTL: 300},
+	}
+	tests := []struct {
+		name       string
+		recordType string
+		ipv4, ipv6 string
+		status     []string
+	}{
+		{"A without IPv4", "A", "", "2001:db8::1", []string{
+			"a.example.com A 198.51.100.1 - unknown",
+		}},
+		{"AAAA without IPv6", "AAAA", "203.0.113.1", "", []string{
+			"a.example.com AAAA 2001:db8::2 - unknown",
+		}},
+		{"both without IPv6", "both", "203.0.113.1", "", []string{
+			"a.example.com A 198.51.100.1 203.0.113.1 out of date",
+			"a.example.com AAAA 2001:db8::2 - unknown",
+		}},
+		{"both without any address", "both", "", "", []string{
+			"a.example.com A 198.51.100.1 - unknown",
+			"a.example.com AAAA 2001:db8::2 - unknown",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domains := []DomainConfig{{Name: "a.example.com", RecordTypes: tt.recordType}}
+			u, api := newTestUpdater(t, domains, records, tt.ipv4, tt.ipv6)
+
+			plan, err := u.Plan(context.Background())
+			if err == nil || !strings.Contains(err.Error(), "no address") {
+				t.Fatalf("error = %v, want the detection failure", err)
+			}
+			if len(api.changes) > 0 {
+				t.Errorf("plan made changes: %v", api.changes)
+			}
+
+			var out strings.Builder
+			if err := plan.PrintStatus(&out); err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")[1:]
+			for i := range lines {
+				lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+			}
+			if !slices.Equal(lines, tt.status) {
+				t.Errorf("status =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(tt.status, "\n"))
+			}
+		})
+	}
+}
+
+// recordingNotifier remembers the events it is sent
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestTrackFailures(t *testing.T) {
+	failure := errors.New("boom")
+	tests := []struct {
+		name      string
+		threshold int
+		results   []error
+		want      []string
+	}{
+		{
+			name:      "below threshold",
+			threshold: 3,
+			results:   []error{failure, failure, nil, failure},
+			want:      nil,
+		},
+		{
+			name:      "reaches threshold once",
+			threshold: 3,
+			results:   []error{failure, failure, failure, failure, failure},
+			want:      []string{"domain_failed after 3"},
+		},
+		{
+			name:      "recovers",
+			threshold: 3,
+			results:   []error{failure, failure, failure, failure, nil, nil},
+			want:      []string{"domain_failed after 3", "domain_recovered after 4"},
+		},
+		{
+			name:      "counter resets after recovery",
+			threshold: 2,
+			results:   []error{failure, failure, nil, failure, nil, failure, failure},
+			want:      []string{"domain_failed after 2", "domain_recovered after 2", "domain_failed after 2"},
+		},
+		{
+			name:      "threshold of one",
+			threshold: 1,
+			results:   []error{failure, nil},
+			want:      []string{"domain_failed after 1", "domain_recovered after 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &recordingNotifier{}
+			u := &DDNSUpdater{
+				config:   &Config{Notifications: NotificationsConfig{FailureThreshold: tt.threshold}},
+				failures: make(map[string]int),
+				notifier: &Notifications{targets: []notifyTarget{{name: "test", notifier: recorder}}},
+			}
+
+			for _, err := range tt.results {
+				u.trackFailures(context.Background(), "home.example.com", err)
+			}
+
+			var got []string
+			for _, event := range recorder.events {
+				if event.Domain != "home.example.com" {
+					t.Errorf("event for %q", event.Domain)
+				}
+				got = append(got, fmt.Sprintf("%s after %d", event.Type, event.Failures))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+			if len(recorder.events) > 0 && recorder.events[0].Error != "boom" {
+				t.Errorf("failure event error = %q, want boom", recorder.events[0].Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+/*
+Cloudflare Dynamic DNS Updater
+Copyright (C) 2025
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// watchConfigFile notifies on the returned channel whenever the config file
+// is rewritten or replaced. The directory is watched rather than the file
+// itself so editors that save by renaming a temporary file are picked up.
+func watchConfigFile(ctx context.Context, path string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	// A non-blocking descriptor lets the runtime poller interrupt Read on Close
+	file := os.NewFile(uintptr(fd), "inotify")
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				nameLen := int(binary.NativeEndian.Uint32(buf[offset+12 : offset+16]))
+				start := offset + syscall.SizeofInotifyEvent
+				end := min(start+nameLen, n)
+				eventName := strings.TrimRight(string(buf[start:end]), "\x00")
+				offset = end
+
+				if eventName != name {
+					continue
+				}
+
+				// Coalesce bursts of events into a single pending notification
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}